@@ -5,5 +5,13 @@ go 1.21
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/elgris/sqrl v0.0.0-20190909141434-5a439265eeec
+	github.com/jackc/pgx/v5 v5.5.5
 	github.com/lib/pq v1.10.3
 )
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
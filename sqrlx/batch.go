@@ -0,0 +1,69 @@
+package sqrlx
+
+import (
+	"context"
+	"fmt"
+)
+
+// ExecBatch runs each statement in order with ExecRaw, stopping at the first
+// error, which is returned as a BatchError. Pass a Transaction to run the batch
+// atomically. Unlike a single semicolon joined statement, the error identifies
+// the statement which failed.
+func ExecBatch(ctx context.Context, q Commander, statements ...string) error {
+	for idx, statement := range statements {
+		if _, err := q.ExecRaw(ctx, statement); err != nil {
+			return &BatchError{
+				Index: idx,
+				Err:   err,
+			}
+		}
+	}
+	return nil
+}
+
+// InsertStructBatched inserts srcs, as InsertStruct, in statements of at most
+// batchSize rows, returning the total rows affected. A batchSize of zero or
+// less fits as many rows as PostgresMaxParams allows. Pass a Transaction to
+// insert every batch atomically, otherwise batches before a failure remain.
+// A failed batch is returned as a BatchError, with Index counting batches.
+func InsertStructBatched(ctx context.Context, q Commander, table string, batchSize int, srcs ...interface{}) (int64, error) {
+	if len(srcs) == 1 {
+		srcs = expandSlice(srcs[0], srcs)
+	}
+	if len(srcs) == 0 {
+		return 0, nil
+	}
+
+	if batchSize <= 0 {
+		first, err := InsertStruct(table, srcs[0])
+		if err != nil {
+			return 0, err
+		}
+		_, args, err := first.ToSql()
+		if err != nil {
+			return 0, err
+		}
+		batchSize = max(PostgresMaxParams/max(len(args), 1), 1)
+	}
+
+	var total int64
+	for batch, start := 0, 0; start < len(srcs); batch, start = batch+1, start+batchSize {
+		bb, err := InsertStruct(table, srcs[start:min(start+batchSize, len(srcs))]...)
+		if err != nil {
+			return total, err
+		}
+		res, err := q.Exec(ctx, bb)
+		if err != nil {
+			return total, &BatchError{
+				Index: batch,
+				Err:   err,
+			}
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("rows affected by batch %d: %w", batch, err)
+		}
+		total += count
+	}
+	return total, nil
+}
@@ -0,0 +1,73 @@
+package sqrlx
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExecBatch(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectExec("CREATE TABLE a").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE b").WillReturnError(testError("syntax error"))
+
+	err := ExecBatch(ctx, tx,
+		"CREATE TABLE a (id int)",
+		"CREATE TABLE b (id int",
+		"CREATE TABLE c (id int)",
+	)
+
+	batchErr := &BatchError{}
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected BatchError, got %v", err)
+	}
+	if batchErr.Index != 1 {
+		t.Errorf("Expected index 1, got %d", batchErr.Index)
+	}
+
+	queryErr := &QueryError{}
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected QueryError, got %v", err)
+	}
+	if queryErr.Statement != "CREATE TABLE b (id int" {
+		t.Errorf("Expected only the failed statement, got %q", queryErr.Statement)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestInsertStructBatched(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	type row struct {
+		ID string `sql:"id"`
+	}
+	rows := []row{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}, {ID: "5"}}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO a (id) VALUES (!),(!)")).
+		WithArgs("1", "2").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO a (id) VALUES (!),(!)")).
+		WithArgs("3", "4").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO a (id) VALUES (!)")).
+		WithArgs("5").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	count, err := InsertStructBatched(ctx, tx, "a", 2, rows)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 5 {
+		t.Errorf("Expected 5 rows affected, got %d", count)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,290 @@
+package sqrlx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ErrEmptyStatement is returned, wrapped in a QueryError, when a Sqlizer
+// renders an empty statement, which is always a bug in the builder
+var ErrEmptyStatement = errors.New("empty statement")
+
+// ErrTooManyRows is returned when a result has more rows than allowed by
+// WithMaxRows, which usually means a missing LIMIT or WHERE clause
+var ErrTooManyRows = errors.New("too many rows")
+
+// ErrTooManyParams is returned, wrapped in a QueryError, when a statement has
+// more params than the Wrapper MaxParams, before it is sent to the driver
+var ErrTooManyParams = errors.New("too many params")
+
+// ErrDryRun is returned, wrapped in a QueryError, by statements on the pool
+// which write and return rows, e.g. ExecReturning, when the Wrapper DryRun is
+// set, as they can't be skipped and still return their rows
+var ErrDryRun = errors.New("statement which writes and returns rows can't run in a dry run")
+
+// ErrShuttingDown is returned by Transact after the Wrapper is closed
+var ErrShuttingDown = errors.New("wrapper is shutting down")
+
+// QueryError is thrown by all exec and query commands to wrap the driver error.
+// It includes the statement causing the error
+type QueryError struct {
+	cause     error
+	Statement string
+
+	// Op is the operation which ran the statement, one of OpExec, OpQuery or
+	// OpSelect as passed to a TypedQueryLogger. It is empty for errors
+	// building the statement, e.g. ErrEmptyStatement, and from Prepare.
+	Op string
+
+	// ctxErr is the context error when the statement context was done, and
+	// the driver returned its own error in place of the context error
+	ctxErr error
+}
+
+// newQueryError wraps the driver error from running statement with ctx, as op
+func newQueryError(ctx context.Context, err error, op string, statement string) *QueryError {
+	queryErr := &QueryError{
+		cause:     err,
+		Statement: statement,
+		Op:        op,
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		queryErr.ctxErr = ctxErr
+	}
+	return queryErr
+}
+
+// Cause gives the driver error which was thrown
+func (err QueryError) Unwrap() error {
+	return err.cause
+}
+
+// Is matches context.Canceled and context.DeadlineExceeded when the statement
+// context was done, even when the driver error does not wrap the context error
+func (err QueryError) Is(target error) bool {
+	return err.ctxErr != nil && errors.Is(err.ctxErr, target)
+}
+
+// Error is the cause error + the statement causing it
+func (err QueryError) Error() string {
+	return err.cause.Error() + " `" + err.Statement + "` "
+}
+
+// Kind classifies the driver error, e.g. to map it to an API status. Use
+// KindOf for errors which may not be a QueryError.
+func (err QueryError) Kind() ErrorKind {
+	return classifyKind(err.cause)
+}
+
+// BatchError is returned by ExecBatch and InsertStructBatched when a statement
+// fails. Err is the error of that statement alone, a *QueryError when run
+// through a Wrapper.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (err BatchError) Unwrap() error {
+	return err.Err
+}
+
+func (err BatchError) Error() string {
+	return fmt.Sprintf("batch statement %d: %s", err.Index, err.Err.Error())
+}
+
+// TransactPhase is the step of a transaction which failed
+type TransactPhase int
+
+const (
+	// PhaseBegin is a failure to begin the transaction, e.g. when the pool
+	// is exhausted, or of a statement run by TxOptions on begin. Invalid
+	// options and ErrShuttingDown, before any attempt, are also PhaseBegin.
+	PhaseBegin TransactPhase = iota + 1
+
+	// PhaseCallback is an error returned by the callback, or a failure to
+	// roll back after it
+	PhaseCallback
+
+	// PhaseCommit is a failure to commit, or to roll back a transaction
+	// marked with MarkRollback
+	PhaseCommit
+)
+
+func (phase TransactPhase) String() string {
+	switch phase {
+	case PhaseBegin:
+		return "begin"
+	case PhaseCallback:
+		return "callback"
+	case PhaseCommit:
+		return "commit"
+	default:
+		return "unknown"
+	}
+}
+
+// TransactError is returned by Transact, recording the phase of the final
+// attempt which failed. Error is unchanged from the wrapped error.
+type TransactError struct {
+	Phase TransactPhase
+	Err   error
+}
+
+func (err TransactError) Unwrap() error {
+	return err.Err
+}
+
+func (err TransactError) Error() string {
+	return err.Err.Error()
+}
+
+// CommitAmbiguousError is returned when a commit failed because the
+// connection was lost, so the transaction may or may not have been committed by
+// the server. Other commit errors, such as a serialization failure, mean the
+// transaction was definitely rolled back.
+type CommitAmbiguousError struct {
+	Err error
+}
+
+func (err CommitAmbiguousError) Unwrap() error {
+	return err.Err
+}
+
+func (err CommitAmbiguousError) Error() string {
+	return "commit outcome unknown: " + err.Err.Error()
+}
+
+// isCommitAmbiguous returns true when a commit error was caused by the
+// connection rather than the server rejecting the commit
+func isCommitAmbiguous(err error) bool {
+	if isConnectionError(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// ErrorKind is a coarse classification of database errors
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+
+	// KindNotFound is sql.ErrNoRows, which Row.Scan and Row.ScanStruct
+	// return unwrapped, so it is only seen by KindOf, not QueryError.Kind
+	KindNotFound
+
+	// KindConflict is a unique or exclusion constraint violation
+	KindConflict
+
+	// KindConstraint is any other integrity constraint violation, e.g. a
+	// foreign key or check constraint
+	KindConstraint
+
+	// KindTransient errors may succeed if retried, as classified by the
+	// default transaction retry logic, or a lost connection
+	KindTransient
+)
+
+func (kind ErrorKind) String() string {
+	switch kind {
+	case KindNotFound:
+		return "not found"
+	case KindConflict:
+		return "conflict"
+	case KindConstraint:
+		return "constraint"
+	case KindTransient:
+		return "transient"
+	default:
+		return "unknown"
+	}
+}
+
+// KindOf classifies any error returned by the Wrapper, including
+// sql.ErrNoRows from Row.Scan, which is not a QueryError, and errors wrapped
+// with fmt.Errorf %w. Errors which are not database errors are KindUnknown.
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return KindUnknown
+	}
+	return classifyKind(err)
+}
+
+func classifyKind(err error) ErrorKind {
+	if errors.Is(err, sql.ErrNoRows) {
+		return KindNotFound
+	}
+	if defaultShouldRetry(err) || isConnectionError(err) {
+		return KindTransient
+	}
+
+	state := sqlState(err)
+	switch {
+	case state == "23505", state == "23P01":
+		// unique_violation, exclusion_violation
+		return KindConflict
+	case strings.HasPrefix(state, "23"):
+		// integrity_constraint_violation class
+		return KindConstraint
+	case strings.HasPrefix(state, "08"):
+		// connection_exception class
+		return KindTransient
+	}
+	return KindUnknown
+}
+
+// sqlState returns the SQLSTATE code of a driver error, or an empty string if
+// it has none. The error chain is walked, so driver errors wrapped in a
+// QueryError or with fmt.Errorf %w are found.
+func sqlState(err error) string {
+	// github.com/lib/pq
+	var getPGCodeErr interface {
+		Get(byte) string
+	}
+	if errors.As(err, &getPGCodeErr) {
+		return getPGCodeErr.Get('C')
+	}
+
+	// TODO: Other drivers. Really this should be part of the database/sql library.
+	return ""
+}
+
+// ClassifyError explains the default retry decision for err, returning whether
+// it is retryable, the SQLSTATE extracted from the driver error, if any, and a
+// human readable reason, e.g. for logging why a transaction was not retried.
+func ClassifyError(err error) (retryable bool, state string, reason string) {
+	if err == nil {
+		return false, "", "no error"
+	}
+
+	state = sqlState(err)
+	switch state {
+	case "":
+		return false, "", fmt.Sprintf("no SQLSTATE, not a pq error (%T)", err)
+	case "40001":
+		// serilaization failure, in the SQL standard. The transaction
+		// conflicted with a concurrent one under its isolation level.
+		return true, state, "serialization failure"
+	case "40P01":
+		// deadlock detected, Postgres specific. The transaction was chosen
+		// to abort to break a lock cycle, any isolation level can see it.
+		return true, state, "deadlock detected"
+	}
+	return false, state, "SQLSTATE " + state + " is not retryable"
+}
+
+// isDeadlock returns true when err is a Postgres deadlock_detected error
+func isDeadlock(err error) bool {
+	return sqlState(err) == "40P01"
+}
+
+func defaultShouldRetry(err error) bool {
+	retryable, _, _ := ClassifyError(err)
+	return retryable
+}
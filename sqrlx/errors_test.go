@@ -0,0 +1,103 @@
+package sqrlx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestQueryErrorKind(t *testing.T) {
+
+	for _, tc := range []struct {
+		cause  error
+		expect ErrorKind
+	}{
+		{cause: sql.ErrNoRows, expect: KindNotFound},
+		{cause: &pq.Error{Code: "23505"}, expect: KindConflict},
+		{cause: &pq.Error{Code: "23503"}, expect: KindConstraint},
+		{cause: &pq.Error{Code: "40001"}, expect: KindTransient},
+		{cause: &pq.Error{Code: "40P01"}, expect: KindTransient},
+		{cause: &pq.Error{Code: "08006"}, expect: KindTransient},
+		{cause: sql.ErrConnDone, expect: KindTransient},
+		{cause: &pq.Error{Code: "42P01"}, expect: KindUnknown},
+		{cause: testError("other"), expect: KindUnknown},
+	} {
+		t.Run(tc.cause.Error()+" "+tc.expect.String(), func(t *testing.T) {
+			err := &QueryError{
+				cause:     tc.cause,
+				Statement: "SELECT",
+			}
+			if got := err.Kind(); got != tc.expect {
+				t.Errorf("Expected %s, got %s", tc.expect, got)
+			}
+			if !errors.Is(err, tc.cause) {
+				t.Errorf("QueryError should still wrap the cause")
+			}
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT a FROM b").WillReturnRows(sqlmock.NewRows([]string{"a"}))
+	mock.ExpectQuery("INSERT INTO b").WillReturnError(&pq.Error{Code: "23505"})
+
+	var a int
+	err := tx.QueryRow(ctx, testSqlizer{str: "SELECT a FROM b"}).Scan(&a)
+	if got := KindOf(err); got != KindNotFound {
+		t.Errorf("Expected not found for no rows, got %s (%v)", got, err)
+	}
+	if got := KindOf(fmt.Errorf("loading: %w", err)); got != KindNotFound {
+		t.Errorf("Expected not found through a wrapped error, got %s", got)
+	}
+
+	_, err = tx.InsertReturningID(ctx, testSqlizer{str: "INSERT INTO b (a) VALUES (1)"}, "id")
+	if got := KindOf(err); got != KindConflict {
+		t.Errorf("Expected conflict from the QueryError, got %s (%v)", got, err)
+	}
+
+	if got := KindOf(nil); got != KindUnknown {
+		t.Errorf("Expected unknown for nil, got %s", got)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+		state     string
+		reason    string
+	}{
+		{err: &pq.Error{Code: "40001"}, retryable: true, state: "40001", reason: "serialization failure"},
+		{err: &pq.Error{Code: "40P01"}, retryable: true, state: "40P01", reason: "deadlock detected"},
+		{err: &pq.Error{Code: "23505"}, retryable: false, state: "23505", reason: "SQLSTATE 23505 is not retryable"},
+		{err: testError("other"), retryable: false, state: "", reason: "no SQLSTATE, not a pq error (sqrlx.testError)"},
+		{err: nil, retryable: false, state: "", reason: "no error"},
+	} {
+		retryable, state, reason := ClassifyError(tc.err)
+		if retryable != tc.retryable || state != tc.state || reason != tc.reason {
+			t.Errorf("ClassifyError(%v): expected (%v, %q, %q), got (%v, %q, %q)",
+				tc.err, tc.retryable, tc.state, tc.reason, retryable, state, reason)
+		}
+	}
+}
+
+func TestShouldRetryWrapped(t *testing.T) {
+	cause := &pq.Error{Code: "40001"}
+	for _, err := range []error{
+		&QueryError{cause: cause, Statement: "UPDATE foo SET bar = 1"},
+		fmt.Errorf("in callback: %w", &QueryError{cause: cause}),
+	} {
+		if !defaultShouldRetry(err) {
+			t.Errorf("Expected %v to be retryable", err)
+		}
+	}
+}
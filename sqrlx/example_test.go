@@ -0,0 +1,77 @@
+package sqrlx_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	sq "github.com/elgris/sqrl"
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+// sql.Out params are passed to the driver unchanged, for drivers which support
+// OUT parameters, e.g. SQL Server or Oracle.
+func ExampleWrapper_ExecRaw_outParameter() {
+	ctx := context.Background()
+
+	var db *sql.DB // opened with a driver supporting sql.Out
+	wrapper, err := sqrlx.New(db, sqrlx.Question)
+	if err != nil {
+		panic(err)
+	}
+
+	var total int64
+	if _, err := wrapper.ExecRaw(ctx, "CALL order_total(?, ?)", "order-1", sql.Out{Dest: &total}); err != nil {
+		panic(err)
+	}
+	fmt.Println(total)
+}
+
+// Postgres returns the OUT parameters of a procedure as a row from CALL, so
+// pass NULL for each OUT parameter and scan the row instead.
+func ExampleWrapper_QueryRowRaw_procedure() {
+	ctx := context.Background()
+
+	var db *sql.DB // opened with lib/pq
+	wrapper := sqrlx.NewPostgres(db)
+
+	var total int64
+	if err := wrapper.QueryRowRaw(ctx, "CALL order_total($1, NULL)", "order-1").Scan(&total); err != nil {
+		panic(err)
+	}
+	fmt.Println(total)
+}
+
+// QueryRow runs the statement exactly once, so is safe for writes, and
+// Row.ScanStruct reads the returned columns into a struct.
+func ExampleWrapper_QueryRow_returningStruct() {
+	ctx := context.Background()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (name) VALUES ($1) RETURNING id, name")).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "alice"))
+
+	wrapper := sqrlx.NewPostgres(db)
+
+	type user struct {
+		ID   int64  `sql:"id"`
+		Name string `sql:"name"`
+	}
+
+	inserted := user{}
+	if err := wrapper.QueryRow(ctx, sq.Insert("users").
+		Columns("name").
+		Values("alice").
+		Suffix("RETURNING id, name"),
+	).ScanStruct(&inserted); err != nil {
+		panic(err)
+	}
+	fmt.Println(inserted.ID, inserted.Name)
+	// Output: 1 alice
+}
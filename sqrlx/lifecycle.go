@@ -0,0 +1,67 @@
+package sqrlx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// lifecycle tracks active transactions, shared by copies of the Wrapper
+type lifecycle struct {
+	lock    sync.Mutex
+	closing bool
+	active  sync.WaitGroup
+}
+
+// startTransaction returns ErrShuttingDown once Close has been called,
+// otherwise the returned func must be called when the transaction ends
+func (w Wrapper) startTransaction() (func(), error) {
+	if w.lifecycle == nil {
+		return func() {}, nil
+	}
+	w.lifecycle.lock.Lock()
+	defer w.lifecycle.lock.Unlock()
+	if w.lifecycle.closing {
+		return nil, ErrShuttingDown
+	}
+	w.lifecycle.active.Add(1)
+	return w.lifecycle.active.Done, nil
+}
+
+// Close stops new transactions, which return ErrShuttingDown, and waits for
+// active transactions to end or for ctx to be done. Statements run directly
+// on the Wrapper are not tracked. When OwnsConnection is set, the connection is
+// then closed, including when ctx is done first.
+func (w *Wrapper) Close(ctx context.Context) error {
+	var waitErr error
+	if w.lifecycle != nil {
+		w.lifecycle.lock.Lock()
+		w.lifecycle.closing = true
+		w.lifecycle.lock.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			w.lifecycle.active.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			waitErr = fmt.Errorf("waiting for transactions: %w", ctx.Err())
+		}
+	}
+
+	if !w.OwnsConnection {
+		return waitErr
+	}
+	closer, ok := w.db.(io.Closer)
+	if !ok {
+		return waitErr
+	}
+	if err := closer.Close(); err != nil {
+		return errors.Join(waitErr, fmt.Errorf("closing connection: %w", err))
+	}
+	return waitErr
+}
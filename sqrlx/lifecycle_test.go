@@ -0,0 +1,120 @@
+package sqrlx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestWrapperClose(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.OwnsConnection = true
+
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	txErr := make(chan error, 1)
+	go func() {
+		txErr <- w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- w.Close(ctx)
+	}()
+
+	// Close is waiting, new transactions are rejected
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned before the transaction ended: %v", err)
+	default:
+	}
+	err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+		t.Error("Callback should not run after Close")
+		return nil
+	})
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown, got %v", err)
+	}
+	beginErr := &TransactError{}
+	if !errors.As(err, &beginErr) || beginErr.Phase != PhaseBegin {
+		t.Errorf("Expected a begin TransactError, got %v", err)
+	}
+
+	close(release)
+	if err := <-txErr; err != nil {
+		t.Errorf("In-flight transaction failed: %s", err)
+	}
+	if err := <-closed; err != nil {
+		t.Errorf("Close failed: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestWrapperCloseTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	txErr := make(chan error, 1)
+	go func() {
+		txErr <- w.Transact(context.Background(), nil, func(ctx context.Context, tx Transaction) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+
+	close(release)
+	if err := <-txErr; err != nil {
+		t.Errorf("In-flight transaction failed: %s", err)
+	}
+
+	// The connection is not owned, so is still open
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
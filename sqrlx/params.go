@@ -0,0 +1,141 @@
+package sqrlx
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PostgresMaxParams is the most params Postgres accepts in one statement, the
+// default MaxParams for NewPostgres
+const PostgresMaxParams = 65535
+
+// checkParamCount returns an error wrapping ErrTooManyParams when params has
+// more than maxParams elements. Zero maxParams is no limit.
+func checkParamCount(params []interface{}, maxParams int) error {
+	if maxParams <= 0 || len(params) <= maxParams {
+		return nil
+	}
+	return fmt.Errorf("%w: %d params, the limit is %d, pass the values as a single array param, e.g. `= ANY(?)` with pq.Array, in place of one param each", ErrTooManyParams, len(params), maxParams)
+}
+
+// Binary marks a param as binary data, e.g. a large bytea value. The
+// encoding on the wire depends on the driver:
+//
+//   - database/sql drivers receive a []byte. lib/pq sends []byte params as
+//     hex encoded text, doubling the size, unless the connection sets
+//     binary_parameters=yes.
+//   - pgx, through sqrlxpgx.Args, also receives a []byte, which it sends in
+//     the binary format for bytea parameters.
+type Binary []byte
+
+// Value passes the bytes to the driver, NULL for a nil Binary
+func (b Binary) Value() (driver.Value, error) {
+	if b == nil {
+		return nil, nil
+	}
+	return []byte(b), nil
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// isExpandable returns true for slice params which drivers can't accept as a
+// single value. Byte slices and types implementing driver.Valuer, such as
+// pq.Array, are passed through unchanged.
+func isExpandable(param interface{}) bool {
+	if param == nil {
+		return false
+	}
+	rt := reflect.TypeOf(param)
+	if rt.Kind() != reflect.Slice || rt.Elem().Kind() == reflect.Uint8 {
+		return false
+	}
+	return !rt.Implements(valuerType)
+}
+
+// expandSliceParams replaces each placeholder whose param is a slice with a
+// comma separated placeholder per element, and splices the elements into the
+// params, so `id IN (?)` with []int{1, 2} becomes `id IN (?,?)`, and
+// `id IN ($1) AND c = $2` becomes `id IN ($1,$2) AND c = $3`. $N placeholders
+// are renumbered, and may refer to the same param more than once. Other
+// params, including sql.Out, keep their single placeholder and are passed to
+// the driver unchanged. An empty slice is an error, as there is no list of
+// placeholders which behaves the same in both IN and NOT IN, use InClause for
+// lists which may be empty.
+func expandSliceParams(statement string, params []interface{}) (string, []interface{}, error) {
+	widths := make([]int, len(params))
+	expand := false
+	for idx, param := range params {
+		widths[idx] = 1
+		if !isExpandable(param) {
+			continue
+		}
+		expand = true
+		widths[idx] = reflect.ValueOf(param).Len()
+		if widths[idx] == 0 {
+			return "", nil, fmt.Errorf("slice param %d is empty and can't be expanded, use InClause for lists which may be empty", idx+1)
+		}
+	}
+	if !expand {
+		return statement, params, nil
+	}
+
+	expanded := make([]interface{}, 0, len(params))
+	for _, param := range params {
+		if !isExpandable(param) {
+			expanded = append(expanded, param)
+			continue
+		}
+		rv := reflect.ValueOf(param)
+		for elemIdx := 0; elemIdx < rv.Len(); elemIdx++ {
+			expanded = append(expanded, rv.Index(elemIdx).Interface())
+		}
+	}
+
+	// the first $N of each param after expansion
+	numbers := make([]int, len(params))
+	next := 1
+	for idx, width := range widths {
+		numbers[idx] = next
+		next += width
+	}
+
+	buf := &strings.Builder{}
+	last := 0
+	questionIdx := 0
+	for _, ph := range findPlaceholders(statement) {
+		paramIdx := ph.number - 1
+		if ph.number == 0 {
+			paramIdx = questionIdx
+			questionIdx++
+		}
+		if paramIdx >= len(params) {
+			// An error for the driver to report
+			continue
+		}
+
+		buf.WriteString(statement[last:ph.start])
+		last = ph.end
+		for elemIdx := 0; elemIdx < widths[paramIdx]; elemIdx++ {
+			if elemIdx > 0 {
+				buf.WriteByte(',')
+			}
+			if ph.number == 0 {
+				buf.WriteByte('?')
+			} else {
+				fmt.Fprintf(buf, "$%d", numbers[paramIdx]+elemIdx)
+			}
+		}
+	}
+	buf.WriteString(statement[last:])
+	return buf.String(), expanded, nil
+}
+
+// expandParams applies expandSliceParams when ExpandSlices is set
+func (w Wrapper) expandParams(statement string, params []interface{}) (string, []interface{}, error) {
+	if !w.ExpandSlices {
+		return statement, params, nil
+	}
+	return expandSliceParams(statement, params)
+}
@@ -0,0 +1,218 @@
+package sqrlx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestExpandSliceParams(t *testing.T) {
+
+	for _, tc := range []struct {
+		name       string
+		statement  string
+		params     []interface{}
+		expectStmt string
+		expectArgs []interface{}
+	}{{
+		name:       "no slices",
+		statement:  "SELECT a FROM b WHERE c = ?",
+		params:     []interface{}{1},
+		expectStmt: "SELECT a FROM b WHERE c = ?",
+		expectArgs: []interface{}{1},
+	}, {
+		name:       "mixed",
+		statement:  "SELECT a FROM b WHERE c = ? AND d IN (?) AND e = ?",
+		params:     []interface{}{1, []int{2, 3, 4}, "e"},
+		expectStmt: "SELECT a FROM b WHERE c = ? AND d IN (?,?,?) AND e = ?",
+		expectArgs: []interface{}{1, 2, 3, 4, "e"},
+	}, {
+		name:       "escaped",
+		statement:  "SELECT a ?? 'k' FROM b WHERE d IN (?) AND e = 'why?'",
+		params:     []interface{}{[]string{"x", "y"}},
+		expectStmt: "SELECT a ?? 'k' FROM b WHERE d IN (?,?) AND e = 'why?'",
+		expectArgs: []interface{}{"x", "y"},
+	}, {
+		name:       "pass through",
+		statement:  "UPDATE b SET data = ?, tags = ? WHERE id IN (?)",
+		params:     []interface{}{[]byte("data"), pq.StringArray{"t"}, []int64{1}},
+		expectStmt: "UPDATE b SET data = ?, tags = ? WHERE id IN (?)",
+		expectArgs: []interface{}{[]byte("data"), pq.StringArray{"t"}, int64(1)},
+	}, {
+		name:       "dollar",
+		statement:  "SELECT a FROM b WHERE c = $1 AND d IN ($2) AND e = $3 AND f = '$2'",
+		params:     []interface{}{1, []int{2, 3}, "e"},
+		expectStmt: "SELECT a FROM b WHERE c = $1 AND d IN ($2,$3) AND e = $4 AND f = '$2'",
+		expectArgs: []interface{}{1, 2, 3, "e"},
+	}, {
+		name:       "dollar reused",
+		statement:  "SELECT a FROM b WHERE c IN ($2) OR d IN ($2) AND e = $1",
+		params:     []interface{}{"e", []int{2, 3}},
+		expectStmt: "SELECT a FROM b WHERE c IN ($2,$3) OR d IN ($2,$3) AND e = $1",
+		expectArgs: []interface{}{"e", 2, 3},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			stmt, args, err := expandSliceParams(tc.statement, tc.params)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if stmt != tc.expectStmt {
+				t.Errorf("Expected statement %q, got %q", tc.expectStmt, stmt)
+			}
+			if !reflect.DeepEqual(args, tc.expectArgs) {
+				t.Errorf("Expected args %#v, got %#v", tc.expectArgs, args)
+			}
+		})
+	}
+
+	if _, _, err := expandSliceParams("SELECT a FROM b WHERE d NOT IN (?)", []interface{}{[]string{}}); err == nil {
+		t.Errorf("Expected an error for an empty slice")
+	}
+}
+
+func TestRenderExpandsSlices(t *testing.T) {
+	stmt, args, err := NewPostgres(nil, WithSliceExpansion()).RenderSQL(Raw("SELECT a FROM b WHERE c = ? AND d IN (?) AND e = ?", 1, []int{2, 3}, 4))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if stmt != "SELECT a FROM b WHERE c = $1 AND d IN ($2,$3) AND e = $4" {
+		t.Errorf("Unexpected statement %s", stmt)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, 3, 4}) {
+		t.Errorf("Unexpected args %v", args)
+	}
+
+	// Off by default, for drivers which accept slices
+	ids := []int64{1, 2}
+	stmt, args, err = NewPostgres(nil).RenderSQL(Raw("SELECT a FROM b WHERE id = ANY(?)", ids))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if stmt != "SELECT a FROM b WHERE id = ANY($1)" || !reflect.DeepEqual(args, []interface{}{ids}) {
+		t.Errorf("Expected the slice to pass through, got %s %v", stmt, args)
+	}
+}
+
+func TestRawExpandsSlices(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT a FROM b WHERE c IN ($1,$2) AND d = $3")).
+		WithArgs(1, 2, "d").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}))
+
+	w := NewPostgres(db, WithSliceExpansion())
+	rows, err := w.QueryRaw(context.Background(), "SELECT a FROM b WHERE c IN ($1) AND d = $2", []int{1, 2}, "d")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+// outArg matches a sql.Out arg by its destination
+type outArg struct {
+	dest interface{}
+}
+
+func (oa outArg) Match(v driver.Value) bool {
+	out, ok := v.(sql.Out)
+	return ok && out.Dest == oa.dest
+}
+
+func TestOutParamPassThrough(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var total int64
+	out := sql.Out{Dest: &total}
+
+	mock.ExpectExec(regexp.QuoteMeta("CALL order_total($1, $2)")).
+		WithArgs("order-1", outArg{dest: &total}).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := NewPostgres(db)
+	if _, err := w.Exec(context.Background(), Raw("CALL order_total(?, ?)", "order-1", out)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestMaxParams(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT a FROM b WHERE c IN ($1,$2)")).
+		WillReturnRows(sqlmock.NewRows([]string{"a"}))
+
+	w := NewPostgres(db, WithMaxParams(2), WithSliceExpansion())
+	ctx := context.Background()
+
+	rows, err := w.Select(ctx, Raw("SELECT a FROM b WHERE c IN (?)", []int{1, 2}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+
+	// Never reaches the driver
+	_, err = w.Select(ctx, Raw("SELECT a FROM b WHERE c IN (?)", []int{1, 2, 3}))
+	if !errors.Is(err, ErrTooManyParams) {
+		t.Fatalf("Expected ErrTooManyParams, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "3 params, the limit is 2") {
+		t.Errorf("Expected the count and limit in the error, got %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+
+	if NewPostgres(nil).MaxParams != PostgresMaxParams {
+		t.Errorf("Expected NewPostgres to default to PostgresMaxParams")
+	}
+}
+
+func TestBinaryParam(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data := []byte{0, 1, 2, 255}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO blobs (data) VALUES ($1)")).
+		WithArgs(data).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := NewPostgres(db)
+	if _, err := w.Exec(context.Background(), Raw("INSERT INTO blobs (data) VALUES (?)", Binary(data))); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+
+	if val, err := Binary(nil).Value(); err != nil || val != nil {
+		t.Errorf("Expected nil Binary to be NULL, got %v, %v", val, err)
+	}
+}
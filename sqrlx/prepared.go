@@ -0,0 +1,114 @@
+package sqrlx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+type preparer interface {
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+}
+
+// Prepared is a statement prepared on the connection pool, outside of any
+// transaction, to be run many times with different params. Use PrepareRaw
+// for statements within a transaction.
+type Prepared struct {
+	stmt *sql.Stmt
+
+	// statement before the StatementRewriter, which execRaw and queryRaw
+	// apply again for logging
+	statement string
+
+	// bb built the statement, for BuilderName
+	bb Sqlizer
+
+	wrapper *Wrapper
+}
+
+// Prepare builds and prepares bb, replacing placeholders and applying the
+// StatementRewriter once. The params of bb are ignored, params are passed to
+// each Exec or Query instead. The connection must support PrepareContext, as
+// *sql.DB does.
+func (w *Wrapper) Prepare(ctx context.Context, bb Sqlizer) (*Prepared, error) {
+	conn, ok := w.db.(preparer)
+	if !ok {
+		return nil, fmt.Errorf("connection %T does not support Prepare", w.db)
+	}
+
+	statement, _, err := w.render(ctx, bb)
+	if err != nil {
+		return nil, err
+	}
+	rewritten := w.rewriteStatement(ctx, statement)
+
+	stmt, err := conn.PrepareContext(ctx, rewritten)
+	if err != nil {
+		return nil, &QueryError{
+			cause:     err,
+			Statement: rewritten,
+		}
+	}
+	return &Prepared{
+		stmt:      stmt,
+		statement: statement,
+		bb:        bb,
+		wrapper:   w,
+	}, nil
+}
+
+// Exec runs the prepared statement with params. No retries are attempted.
+// With DryRun set on the Wrapper, the statement is logged but not run.
+func (p *Prepared) Exec(ctx context.Context, params ...interface{}) (sql.Result, error) {
+	ctx = withBuilder(ctx, p.bb)
+	return p.wrapper.execRaw(ctx, p.execer(), p.wrapper.currentQueryLogger(), p.statement, params...)
+}
+
+// Query runs the prepared statement with params, returning wrapped rows. No
+// retries are attempted. With DryRun set on the Wrapper, statements which
+// don't start with SELECT, VALUES, SHOW or TABLE fail with ErrDryRun.
+func (p *Prepared) Query(ctx context.Context, params ...interface{}) (*Rows, error) {
+	ctx = withBuilder(ctx, p.bb)
+	return p.wrapper.queryRaw(ctx, p.execer(), p.wrapper.currentQueryLogger(), OpQuery, p.statement, params...)
+}
+
+// Close releases the prepared statement
+func (p *Prepared) Close() error {
+	return p.stmt.Close()
+}
+
+// execer runs the prepared statement, using the wrapper only for logging and
+// timeouts
+func (p *Prepared) execer() queryExecer {
+	return stmtExecer{stmt: p.stmt, dryRun: p.wrapper.DryRun}
+}
+
+// readStatement matches statements which only read, after any leading
+// comments, which a prepared Query may run in a dry run. Anything else,
+// including WITH, which may wrap a write, is treated as a write.
+var readStatement = regexp.MustCompile(`(?is)^(\s|/\*.*?\*/|--[^\n]*\n)*\(?\s*(SELECT|VALUES|SHOW|TABLE)\b`)
+
+// stmtExecer adapts a *sql.Stmt to queryExecer, the statement string is only
+// used for logging as the statement was already prepared.
+type stmtExecer struct {
+	stmt *sql.Stmt
+
+	// dryRun skips each exec, as dryRunExecer does, and fails queries
+	// which don't match readStatement
+	dryRun bool
+}
+
+func (se stmtExecer) QueryContext(ctx context.Context, statement string, params ...interface{}) (*sql.Rows, error) {
+	if se.dryRun && !readStatement.MatchString(statement) {
+		return nil, ErrDryRun
+	}
+	return se.stmt.QueryContext(ctx, params...)
+}
+
+func (se stmtExecer) ExecContext(ctx context.Context, statement string, params ...interface{}) (sql.Result, error) {
+	if se.dryRun {
+		return dryRunExecer{}.ExecContext(ctx, statement, params...)
+	}
+	return se.stmt.ExecContext(ctx, params...)
+}
@@ -0,0 +1,128 @@
+package sqrlx
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPrepared(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO b (c) VALUES (!)"))
+	prep.ExpectExec().WithArgs("c1").WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WithArgs("c2").WillReturnResult(sqlmock.NewResult(2, 1))
+	prep.WillBeClosed()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx := context.Background()
+
+	stmt, err := w.Prepare(ctx, testSqlizer{
+		str:  "INSERT INTO b (c) VALUES (?)",
+		args: []interface{}{"ignored"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, val := range []string{"c1", "c2"} {
+		if _, err := stmt.Exec(ctx, val); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if err := stmt.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestPreparedDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Prepared but never executed
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO b (c) VALUES (!)"))
+	prep.WillBeClosed()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.DryRun = true
+
+	logged := []string{}
+	w.QueryLogger = CallbackLogger(func(ctx context.Context, statement string) {
+		logged = append(logged, statement)
+	})
+
+	ctx := context.Background()
+
+	stmt, err := w.Prepare(ctx, testSqlizer{
+		str: "INSERT INTO b (c) VALUES (?)",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := stmt.Exec(ctx, "c1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count, err := res.RowsAffected(); err != nil || count != 0 {
+		t.Errorf("Expected 0 rows affected, got %d, %v", count, err)
+	}
+
+	// The rows of a write can't be faked
+	if _, err := stmt.Query(ctx, "c1"); !errors.Is(err, ErrDryRun) {
+		t.Errorf("Expected ErrDryRun from Query, got %v", err)
+	}
+
+	if err := stmt.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Reads still run
+	prep = mock.ExpectPrepare(regexp.QuoteMeta("/* lookup */ SELECT c FROM b WHERE c = !"))
+	prep.ExpectQuery().WithArgs("c1").WillReturnRows(sqlmock.NewRows([]string{"c"}).AddRow("c1"))
+	prep.WillBeClosed()
+
+	read, err := w.Prepare(ctx, testSqlizer{str: "/* lookup */ SELECT c FROM b WHERE c = ?"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows, err := read.Query(ctx, "c1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := read.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.Contains(strings.Join(logged, "\n"), "INSERT INTO b (c) VALUES (!)") {
+		t.Errorf("Expected the statement to be logged, got %q", logged)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -1,8 +1,12 @@
 package sqrlx
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 )
 
 // IRows is the interface of *sql.Rows
@@ -18,6 +22,130 @@ var _ IRows = &sql.Rows{}
 
 type Rows struct {
 	IRows
+
+	closed   bool
+	closeErr error
+
+	// cancel releases the query context, if the query had its own
+	cancel context.CancelFunc
+
+	// release ends the read only scope of a strict read, if any
+	release func() error
+}
+
+// Close closes the underlying rows once, further calls return the result of
+// the first call.
+func (r *Rows) Close() error {
+	if r.closed {
+		return r.closeErr
+	}
+	r.closed = true
+	r.closeErr = r.IRows.Close()
+	if r.release != nil {
+		if err := r.release(); err != nil && r.closeErr == nil {
+			r.closeErr = fmt.Errorf("ending strict read: %w", err)
+		}
+	}
+	if r.cancel != nil {
+		r.cancel()
+	}
+	return r.closeErr
+}
+
+// ScanOption modifies how Each and ScanAll iterate rows
+type ScanOption func(*scanOptions)
+
+type scanOptions struct {
+	// maxRows is the most rows to iterate, zero for no limit
+	maxRows int
+}
+
+func newScanOptions(options []ScanOption) scanOptions {
+	opts := scanOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// checkRow returns ErrTooManyRows when the row at idx, counting from zero, is
+// beyond the limit
+func (opts scanOptions) checkRow(idx int) error {
+	if opts.maxRows > 0 && idx >= opts.maxRows {
+		return fmt.Errorf("%w: more than %d", ErrTooManyRows, opts.maxRows)
+	}
+	return nil
+}
+
+// WithMaxRows stops iteration with ErrTooManyRows when the result has more
+// than n rows, closing the rows without reading the remainder. The first n
+// rows are still passed to fn, or scanned and returned by ScanAll, with the
+// error.
+func WithMaxRows(n int) ScanOption {
+	return func(opts *scanOptions) {
+		opts.maxRows = n
+	}
+}
+
+// Each calls fn for each row, then closes the rows. Iteration stops at the
+// first error from fn.
+func (r *Rows) Each(fn func(Scannable) error, options ...ScanOption) error {
+	_, err := r.EachCounted(fn, options...)
+	return err
+}
+
+// EachCounted is Each, also returning the number of rows for which fn
+// returned without error.
+func (r *Rows) EachCounted(fn func(Scannable) error, options ...ScanOption) (int, error) {
+	defer r.Close()
+
+	opts := newScanOptions(options)
+	count := 0
+	for r.Next() {
+		if err := opts.checkRow(count); err != nil {
+			return count, err
+		}
+		if err := fn(r); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := r.Err(); err != nil {
+		return count, err
+	}
+	return count, r.Close()
+}
+
+// EachInto scans each row into dest, a pointer to a struct with sql tags, and
+// calls fn after each scan, then closes the rows. dest is zeroed before each
+// scan, so values never carry over from the previous row.
+//
+// The same dest is reused for every row to avoid allocating, so fn must copy
+// anything it needs to keep: dest, and any pointers, slices or maps within it,
+// are overwritten by the next row. Iteration stops at the first error from
+// scanning or from fn.
+func (r *Rows) EachInto(dest interface{}, fn func() error) error {
+	defer r.Close()
+
+	rv, err := structValue("EachInto", dest)
+	if err != nil {
+		return err
+	}
+	zero := reflect.Zero(rv.Type())
+
+	for r.Next() {
+		rv.Set(zero)
+		if err := ScanStruct(r, dest); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	return r.Close()
 }
 
 type Row struct {
@@ -38,32 +166,93 @@ func rowFromRes(rows *Rows, err error) *Row {
 }
 
 func (r Row) Scan(into ...interface{}) error {
-	// partial clone of sql.Row.Scan, but skipping the safety for the RawBytes issue
+	return r.scanRow(func(rows *Rows) error {
+		cols, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if len(cols) != len(into) {
+			return fmt.Errorf("row has %d columns (%s), scanning into %d destinations", len(cols), strings.Join(cols, ", "), len(into))
+		}
+		if err := rows.Scan(into...); err != nil {
+			return err
+		}
+		copyRawBytes(into)
+		return nil
+	})
+}
+
+// scanRow calls scan with the rows positioned on the only row, a partial
+// clone of sql.Row.Scan. Unlike Row, scan may call Scan on the rows more than
+// once.
+func (r Row) scanRow(scan func(*Rows) error) error {
 	if r.err != nil {
 		return r.err
 	}
 
-	defer r.Rows.Close()
-	if !r.Rows.Next() {
-		if err := r.Rows.Err(); err != nil {
+	rows := r.rows()
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
 			return err
 		}
 
 		return sql.ErrNoRows
 	}
-	if err := r.Rows.Scan(into...); err != nil {
+	if err := scan(rows); err != nil {
 		return err
 	}
-	return r.Rows.Close()
+	return rows.Close()
+}
+
+// copyRawBytes copies sql.RawBytes destinations, which point into driver
+// memory which is released when the rows move on or are closed.
+func copyRawBytes(into []interface{}) {
+	for _, dest := range into {
+		if rawBytes, ok := dest.(*sql.RawBytes); ok && *rawBytes != nil {
+			*rawBytes = append(sql.RawBytes{}, *rawBytes...)
+		}
+	}
+}
+
+// rows returns Rows as *Rows, so Close is only passed through once
+func (r Row) rows() *Rows {
+	if rows, ok := r.Rows.(*Rows); ok {
+		return rows
+	}
+	return &Rows{IRows: r.Rows}
 }
 
+// ScanStruct scans the row into a struct with sql tags. sql.ErrNoRows is
+// returned as is, so it can be compared directly, other errors are wrapped.
 func (r Row) ScanStruct(into interface{}) error {
-	if err := ScanStruct(r, into); err != nil {
+	if err := r.scanRow(func(rows *Rows) error {
+		return ScanStruct(rows, into)
+	}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
 		return fmt.Errorf("scan struct: %w", err)
 	}
 	return nil
 }
 
+// Exists returns true when the query returned a row, without scanning it.
+// The rows are closed in either case, and an error from the query is
+// returned in place of false.
+func (r Row) Exists() (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+
+	rows := r.rows()
+	defer rows.Close()
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	return true, rows.Close()
+}
+
 func (r Row) Columns() ([]string, error) {
 	if r.err != nil {
 		return nil, r.err
@@ -1,8 +1,14 @@
 package sqrlx
 
 import (
+	"database/sql"
+	"encoding"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Scannable interface {
@@ -13,26 +19,79 @@ type Scannable interface {
 type walkBaton struct {
 	structCols map[string]interface{}
 	override   bool
+
+	// keyCols collects columns tagged pk, in declaration order, when set
+	keyCols *[]string
+
+	// colOrder collects every column in declaration order, when set
+	colOrder *[]string
+
+	// transform maps tag names to column names, when set
+	transform func(string) string
+
+	// forWrite collects the columns to insert or update, skipping readonly
+	// fields and alternate names
+	forWrite bool
+}
+
+// StructOptions modify how struct fields map to columns
+type StructOptions struct {
+	// ColumnTransform is applied to each sql tag to give the column name,
+	// e.g. to convert camelCase tags to snake_case, or to add a prefix
+	ColumnTransform func(string) string
+}
+
+// embedded returns a baton for the fields of an embedded struct
+func (bb *walkBaton) embedded() *walkBaton {
+	return &walkBaton{
+		structCols: bb.structCols,
+		override:   false,
+		keyCols:    bb.keyCols,
+		colOrder:   bb.colOrder,
+		transform:  bb.transform,
+		forWrite:   bb.forWrite,
+	}
+}
+
+// tagOptions are the comma separated values following the column name in a
+// sql tag, e.g. `sql:"id,pk"`. Options are:
+//
+//   - pk: the column is part of the primary key, see StructKeyColumns
+//   - readonly: the column is scanned, but never inserted or updated, e.g. a
+//     generated or identity column
+type tagOptions []string
+
+func parseTag(tag string) (string, tagOptions) {
+	parts := strings.Split(tag, ",")
+	return parts[0], tagOptions(parts[1:])
+}
+
+func (opts tagOptions) has(option string) bool {
+	for _, opt := range opts {
+		if strings.TrimSpace(opt) == option {
+			return true
+		}
+	}
+	return false
 }
 
 func addNamed(bb *walkBaton, rv reflect.Value) error {
 
 	// TODO: Check types to raise errors
 	rt := rv.Type()
+	// tag name to field name, for fields directly in this struct
+	fieldsByTag := map[string]string{}
 	for i := 0; i < rv.NumField(); i++ {
 
 		field := rt.Field(i)
 
 		tag := field.Tag
-		tagName := tag.Get("sql")
+		tagName, tagOpts := parseTag(tag.Get("sql"))
 		if tagName == "-" {
 			continue
 		}
 		if field.Anonymous && field.Type.Kind() == reflect.Struct {
-			if err := addNamed(&walkBaton{
-				structCols: bb.structCols,
-				override:   false,
-			}, rv.Field(i)); err != nil {
+			if err := addNamed(bb.embedded(), rv.Field(i)); err != nil {
 				return err
 			}
 			continue
@@ -40,10 +99,7 @@ func addNamed(bb *walkBaton, rv reflect.Value) error {
 
 		if field.Anonymous && field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
 			val := reflect.New(field.Type.Elem())
-			if err := addNamed(&walkBaton{
-				structCols: bb.structCols,
-				override:   false,
-			}, val.Elem()); err != nil {
+			if err := addNamed(bb.embedded(), val.Elem()); err != nil {
 				return err
 			}
 			rv.Field(i).Set(val)
@@ -54,52 +110,130 @@ func addNamed(bb *walkBaton, rv reflect.Value) error {
 			continue
 		}
 
+		// Alternate names, e.g. `sql:"email|email_address"` during a
+		// rename, all scan into the field. The first is used to write.
+		names := strings.Split(tagName, "|")
+		for idx, name := range names {
+			if existing, ok := fieldsByTag[name]; ok {
+				return fmt.Errorf("duplicate sql tag %q on fields %s and %s of %s", name, existing, field.Name, rt)
+			}
+			fieldsByTag[name] = field.Name
+
+			if bb.transform != nil {
+				names[idx] = bb.transform(name)
+			}
+		}
+
+		if bb.forWrite {
+			if tagOpts.has("readonly") {
+				continue
+			}
+			names = names[:1]
+		}
+
+		if bb.keyCols != nil && tagOpts.has("pk") {
+			*bb.keyCols = append(*bb.keyCols, names[0])
+		}
+
 		fieldInterface := rv.Field(i).Addr().Interface()
 
-		if bb.override {
-			bb.structCols[tagName] = fieldInterface
-		} else if _, ok := bb.structCols[tagName]; !ok {
-			bb.structCols[tagName] = fieldInterface
+		for idx, name := range names {
+			_, exists := bb.structCols[name]
+			if bb.colOrder != nil && !exists && idx == 0 {
+				*bb.colOrder = append(*bb.colOrder, name)
+			}
+
+			if bb.override || !exists {
+				bb.structCols[name] = fieldInterface
+			}
 		}
 	}
 	return nil
 }
 
-func StructColNames(dest interface{}, prefix string) ([]string, error) {
+// structValue returns the struct dest points to, describing what was passed
+// instead when it is not a non nil pointer to a struct. A pointer to an
+// interface holding a pointer to a struct, e.g. &dest where dest is an
+// interface{} holding a *User, is followed to the struct.
+func structValue(funcName string, dest interface{}) (reflect.Value, error) {
+	if dest == nil {
+		return reflect.Value{}, fmt.Errorf("%s requires a pointer to a struct, got nil", funcName)
+	}
 	rv := reflect.ValueOf(dest)
 	if rv.Kind() != reflect.Ptr {
-		return nil, fmt.Errorf("ScanStruct requires a pointer to a struct")
+		return reflect.Value{}, fmt.Errorf("%s requires a pointer to a struct, got %s (kind %s)", funcName, rv.Type(), rv.Kind())
 	}
-	rv = rv.Elem()
-	if rv.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("ScanStruct requires a pointer to a struct")
+	elemKind := rv.Type().Elem().Kind()
+	if elemKind != reflect.Struct && elemKind != reflect.Interface {
+		return reflect.Value{}, fmt.Errorf("%s requires a pointer to a struct, got %s (pointer to %s)", funcName, rv.Type(), elemKind)
+	}
+	if rv.IsNil() {
+		return reflect.Value{}, fmt.Errorf("%s requires a pointer to a struct, got a nil pointer to %s %s", funcName, elemKind, rv.Type().Elem())
+	}
+	elem := rv.Elem()
+	if elemKind == reflect.Interface {
+		if elem.IsNil() {
+			return reflect.Value{}, fmt.Errorf("%s requires a pointer to a struct, got %s holding nil", funcName, rv.Type())
+		}
+		return structValue(funcName, elem.Interface())
 	}
+	return elem, nil
+}
 
-	structCols := map[string]interface{}{}
+func StructColNames(dest interface{}, prefix string) ([]string, error) {
+	rv, err := structValue("StructColNames", dest)
+	if err != nil {
+		return nil, err
+	}
 
+	// Only the first of alternate names is selected
+	colOrder := []string{}
 	if err := addNamed(&walkBaton{
-		structCols: structCols,
+		structCols: map[string]interface{}{},
 		override:   true,
+		colOrder:   &colOrder,
 	}, rv); err != nil {
 		return nil, err
 	}
 
-	names := make([]string, 0, len(structCols))
-	for name := range structCols {
+	names := make([]string, 0, len(colOrder))
+	for _, name := range colOrder {
 		names = append(names, prefix+name)
 	}
 	return names, nil
 }
 
-// ScanStruct scans scannable once, stores vals into the struct.
-func ScanStruct(src Scannable, dest interface{}) error {
-	rv := reflect.ValueOf(dest)
-	if rv.Kind() != reflect.Ptr {
-		return fmt.Errorf("ScanStruct requires a pointer to a struct")
+// StructKeyColumns returns the columns of fields tagged with the pk option,
+// e.g. `sql:"id,pk"`, in declaration order.
+func StructKeyColumns(dest interface{}) ([]string, error) {
+	rv, err := structValue("StructKeyColumns", dest)
+	if err != nil {
+		return nil, err
 	}
-	rv = rv.Elem()
-	if rv.Kind() != reflect.Struct {
-		return fmt.Errorf("ScanStruct requires a pointer to a struct")
+
+	keyCols := []string{}
+	if err := addNamed(&walkBaton{
+		structCols: map[string]interface{}{},
+		override:   true,
+		keyCols:    &keyCols,
+	}, rv); err != nil {
+		return nil, err
+	}
+	return keyCols, nil
+}
+
+// ScanStruct scans scannable once, stores vals into the struct. Fields which
+// implement encoding.TextUnmarshaler, and not sql.Scanner, are scanned from
+// text with UnmarshalText.
+func ScanStruct(src Scannable, dest interface{}) error {
+	return ScanStructOpts(src, dest, StructOptions{})
+}
+
+// ScanStructOpts is ScanStruct, with the columns of dest modified by opts
+func ScanStructOpts(src Scannable, dest interface{}, opts StructOptions) error {
+	rv, err := structValue("ScanStruct", dest)
+	if err != nil {
+		return err
 	}
 
 	structCols := map[string]interface{}{}
@@ -107,6 +241,7 @@ func ScanStruct(src Scannable, dest interface{}) error {
 	if err := addNamed(&walkBaton{
 		structCols: structCols,
 		override:   true,
+		transform:  opts.ColumnTransform,
 	}, rv); err != nil {
 		return err
 	}
@@ -117,6 +252,8 @@ func ScanStruct(src Scannable, dest interface{}) error {
 	}
 
 	toScan := make([]interface{}, len(cols))
+	// field to column, to reject alternate names which are both present
+	scannedBy := make(map[interface{}]string, len(cols))
 
 	for idx, name := range cols {
 		structCol, ok := structCols[name]
@@ -124,8 +261,195 @@ func ScanStruct(src Scannable, dest interface{}) error {
 
 			return fmt.Errorf("No matching struct field for %s", name)
 		}
-		toScan[idx] = structCol
+		if existing, ok := scannedBy[structCol]; ok && existing != name {
+			return fmt.Errorf("columns %s and %s both scan into the same field", existing, name)
+		}
+		scannedBy[structCol] = name
+		toScan[idx] = textScannerFor(structCol)
+	}
+
+	if err := src.Scan(toScan...); err != nil {
+		return scanColumnError(err, src, cols, toScan)
+	}
+	copyRawBytes(toScan)
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// textScannerFor wraps fields implementing encoding.TextUnmarshaler but not
+// sql.Scanner, e.g. enums stored as text, which database/sql would otherwise
+// try to convert directly. Other fields are returned unchanged.
+func textScannerFor(field interface{}) interface{} {
+	fieldType := reflect.TypeOf(field)
+	if fieldType.Implements(scannerType) || !fieldType.Implements(textUnmarshalerType) {
+		return field
+	}
+	return textScanner{dest: field.(encoding.TextUnmarshaler)}
+}
+
+// textScanner scans string and []byte values with UnmarshalText. Other values
+// are converted as database/sql would without the wrapper, e.g. an int64 into
+// an int backed enum, or a time.Time into a time.Time field.
+type textScanner struct {
+	dest encoding.TextUnmarshaler
+}
+
+func (ts textScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return fmt.Errorf("cannot scan NULL into %T", ts.dest)
+	case string:
+		return ts.dest.UnmarshalText([]byte(src))
+	case []byte:
+		return ts.dest.UnmarshalText(src)
+	}
+
+	srcVal := reflect.ValueOf(src)
+	destVal := reflect.ValueOf(ts.dest).Elem()
+	if srcVal.Type().AssignableTo(destVal.Type()) {
+		destVal.Set(srcVal)
+		return nil
+	}
+
+	// As database/sql convertAssign, numbers and bools are formatted then
+	// parsed into the field's kind, so out of range values are an error
+	str := fmt.Sprint(src)
+	switch destVal.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := strconv.ParseInt(str, 10, destVal.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot scan %T (%q) into %T: %w", src, str, ts.dest, err)
+		}
+		destVal.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(str, 10, destVal.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot scan %T (%q) into %T: %w", src, str, ts.dest, err)
+		}
+		destVal.SetUint(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(str, destVal.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot scan %T (%q) into %T: %w", src, str, ts.dest, err)
+		}
+		destVal.SetFloat(val)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(str)
+		if err != nil {
+			return fmt.Errorf("cannot scan %T (%q) into %T: %w", src, str, ts.dest, err)
+		}
+		destVal.SetBool(val)
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, ts.dest)
+	}
+	return nil
+}
+
+var scanErrorColumn = regexp.MustCompile(`column index (\d+)`)
+
+// scanColumnError adds the field type to conversion errors from database/sql,
+// and when the value was NULL, a hint to use a nullable field. The column is
+// taken from the error text, which is not an API, so when it can't be found
+// the error is returned unchanged.
+func scanColumnError(err error, src Scannable, cols []string, toScan []interface{}) error {
+	match := scanErrorColumn.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	idx, convErr := strconv.Atoi(match[1])
+	if convErr != nil || idx >= len(cols) {
+		return err
 	}
+	field := toScan[idx]
+	if ts, ok := field.(textScanner); ok {
+		field = ts.dest
+	}
+	fieldType := reflect.TypeOf(field).Elem()
+	if isNullColumn(src, idx, len(cols)) {
+		return fmt.Errorf("scanning column %s into field of type %s, NULL values need a pointer or sql.Null type: %w", cols[idx], fieldType, err)
+	}
+	return fmt.Errorf("scanning column %s into field of type %s: %w", cols[idx], fieldType, err)
+}
 
-	return src.Scan(toScan...)
+// isNullColumn scans the current row again, which IRows, unlike Row, allows,
+// to find whether the value of column idx is NULL. False when it can't tell.
+func isNullColumn(src Scannable, idx int, colCount int) bool {
+	rows, ok := src.(IRows)
+	if !ok {
+		return false
+	}
+	vals := make([]interface{}, colCount)
+	dest := make([]interface{}, colCount)
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return false
+	}
+	return vals[idx] == nil
+}
+
+var (
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	scannerType   = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// InferGoType returns a type suitable to scan values of the column into.
+//
+// ScanType is used when the driver reports a concrete type, otherwise the type
+// is inferred from DatabaseTypeName, and is interface{} when the name is not
+// recognised. DECIMAL and NUMERIC columns are int64 when DecimalSize reports a
+// scale of zero and a precision of at most 18 digits, which always fit,
+// otherwise string to preserve precision, including when the driver does not
+// report a size, as for an unconstrained NUMERIC.
+//
+// Columns are returned as pointer types unless Nullable reports the column is
+// not nullable, so when the driver does not know, NULL values can still be
+// scanned. Types which handle NULL themselves (interface{}, []byte and
+// sql.Scanner implementations) are never wrapped.
+func InferGoType(col ColumnType) reflect.Type {
+	goType := col.ScanType()
+	if goType == nil || goType.Kind() == reflect.Interface {
+		goType = typeFromDatabaseName(col)
+	}
+
+	if goType.Kind() == reflect.Interface ||
+		goType.Kind() == reflect.Ptr ||
+		goType.Kind() == reflect.Slice ||
+		reflect.PtrTo(goType).Implements(scannerType) {
+		return goType
+	}
+
+	if nullable, ok := col.Nullable(); ok && !nullable {
+		return goType
+	}
+	return reflect.PtrTo(goType)
+}
+
+// maxInt64Digits is the most decimal digits which always fit in an int64
+const maxInt64Digits = 18
+
+func typeFromDatabaseName(col ColumnType) reflect.Type {
+	switch strings.ToUpper(col.DatabaseTypeName()) {
+	case "INT", "INT2", "INT4", "INT8", "INTEGER", "SMALLINT", "BIGINT", "SERIAL", "BIGSERIAL":
+		return reflect.TypeOf(int64(0))
+	case "FLOAT", "FLOAT4", "FLOAT8", "REAL", "DOUBLE", "DOUBLE PRECISION":
+		return reflect.TypeOf(float64(0))
+	case "BOOL", "BOOLEAN":
+		return reflect.TypeOf(false)
+	case "TEXT", "VARCHAR", "CHAR", "BPCHAR", "NAME", "UUID", "CITEXT":
+		return reflect.TypeOf("")
+	case "BYTEA", "BLOB", "JSON", "JSONB":
+		return reflect.TypeOf([]byte{})
+	case "DATE", "TIME", "TIMETZ", "TIMESTAMP", "TIMESTAMPTZ", "DATETIME":
+		return reflect.TypeOf(time.Time{})
+	case "DECIMAL", "NUMERIC":
+		if precision, scale, ok := col.DecimalSize(); ok && scale == 0 && precision > 0 && precision <= maxInt64Digits {
+			return reflect.TypeOf(int64(0))
+		}
+		return reflect.TypeOf("")
+	default:
+		return interfaceType
+	}
 }
@@ -1,6 +1,10 @@
 package sqrlx
 
 import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -22,6 +26,9 @@ func (ms *MockRows) Columns() ([]string, error) {
 }
 
 func (ms *MockRows) Close() error {
+	if ms.DidClose {
+		return fmt.Errorf("rows already closed")
+	}
 	ms.DidClose = true
 	return nil
 }
@@ -82,3 +89,352 @@ func TestScanErrors(t *testing.T) {
 	})
 
 }
+
+type MockColumnType struct {
+	name         string
+	databaseType string
+	scanType     reflect.Type
+	nullable     bool
+	nullableOK   bool
+	precision    int64
+	scale        int64
+	decimalOK    bool
+}
+
+func (mc MockColumnType) DatabaseTypeName() string {
+	return mc.databaseType
+}
+
+func (mc MockColumnType) DecimalSize() (precision, scale int64, ok bool) {
+	return mc.precision, mc.scale, mc.decimalOK
+}
+
+func (mc MockColumnType) Length() (length int64, ok bool) {
+	return 0, false
+}
+
+func (mc MockColumnType) Name() string {
+	return mc.name
+}
+
+func (mc MockColumnType) Nullable() (nullable, ok bool) {
+	return mc.nullable, mc.nullableOK
+}
+
+func (mc MockColumnType) ScanType() reflect.Type {
+	return mc.scanType
+}
+
+func TestInferGoType(t *testing.T) {
+
+	for _, tc := range []struct {
+		name   string
+		col    MockColumnType
+		expect reflect.Type
+	}{{
+		name:   "scan type not null",
+		col:    MockColumnType{scanType: reflect.TypeOf(int32(0)), nullableOK: true},
+		expect: reflect.TypeOf(int32(0)),
+	}, {
+		name:   "scan type nullable unknown",
+		col:    MockColumnType{scanType: reflect.TypeOf(int32(0))},
+		expect: reflect.TypeOf(new(int32)),
+	}, {
+		name:   "scanner",
+		col:    MockColumnType{scanType: reflect.TypeOf(sql.NullString{})},
+		expect: reflect.TypeOf(sql.NullString{}),
+	}, {
+		name:   "database name",
+		col:    MockColumnType{databaseType: "text", nullableOK: true},
+		expect: reflect.TypeOf(""),
+	}, {
+		name:   "interface scan type",
+		col:    MockColumnType{databaseType: "INT8", scanType: interfaceType, nullableOK: true},
+		expect: reflect.TypeOf(int64(0)),
+	}, {
+		name:   "unknown",
+		col:    MockColumnType{databaseType: "GEOMETRY"},
+		expect: interfaceType,
+	}, {
+		name:   "bytes",
+		col:    MockColumnType{databaseType: "JSONB"},
+		expect: reflect.TypeOf([]byte{}),
+	}, {
+		name:   "numeric integer",
+		col:    MockColumnType{databaseType: "NUMERIC", precision: 18, decimalOK: true, nullableOK: true},
+		expect: reflect.TypeOf(int64(0)),
+	}, {
+		name:   "numeric integer too large for int64",
+		col:    MockColumnType{databaseType: "NUMERIC", precision: 20, decimalOK: true, nullableOK: true},
+		expect: reflect.TypeOf(""),
+	}, {
+		name:   "numeric no precision",
+		col:    MockColumnType{databaseType: "DECIMAL", decimalOK: true, nullableOK: true},
+		expect: reflect.TypeOf(""),
+	}, {
+		name:   "numeric unknown size",
+		col:    MockColumnType{databaseType: "NUMERIC", nullableOK: true},
+		expect: reflect.TypeOf(""),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := InferGoType(tc.col)
+			if got != tc.expect {
+				t.Errorf("Expected %s, got %s", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestDuplicateTags(t *testing.T) {
+
+	type duplicated struct {
+		A string `sql:"a"`
+		B string `sql:"a"`
+	}
+
+	ms := &MockRows{
+		ColumnsVal: []string{"a"},
+	}
+
+	if err := ScanStruct(ms, &duplicated{}); err == nil {
+		t.Errorf("ScanStruct should return duplicate error")
+	}
+
+	if _, err := StructColNames(&duplicated{}, ""); err == nil {
+		t.Errorf("StructColNames should return duplicate error")
+	}
+
+	if _, err := InsertStruct("table", &duplicated{}); err == nil {
+		t.Errorf("InsertStruct should return duplicate error")
+	}
+
+	type embedded struct {
+		A string `sql:"a"`
+	}
+
+	type shadowed struct {
+		embedded
+		A string `sql:"a"`
+	}
+
+	if _, err := StructColNames(&shadowed{}, ""); err != nil {
+		t.Errorf("Embedded fields should be shadowed, not duplicates: %s", err)
+	}
+}
+
+func TestStructKeyColumns(t *testing.T) {
+
+	type base struct {
+		TenantID string `sql:"tenant_id,pk"`
+	}
+
+	type keyed struct {
+		base
+		ID   string `sql:"id,pk"`
+		Name string `sql:"name"`
+	}
+
+	keys, err := StructKeyColumns(&keyed{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(keys) != 2 || keys[0] != "tenant_id" || keys[1] != "id" {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+
+	names, err := StructColNames(&keyed{}, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, name := range names {
+		if strings.Contains(name, ",") {
+			t.Errorf("Tag options should not be part of the column name: %s", name)
+		}
+	}
+}
+
+func TestScanStructOpts(t *testing.T) {
+
+	ms := &MockRows{
+		ColumnsVal: []string{"user_id"},
+		ScanImpl: func(vals ...interface{}) error {
+			*(vals[0].(*string)) = "1"
+			return nil
+		},
+	}
+
+	row := &camelTestRow{}
+	if err := ScanStructOpts(ms, row, StructOptions{
+		ColumnTransform: snakeCase,
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if row.UserID != "1" {
+		t.Errorf("Expected user_id to scan into UserID, got %q", row.UserID)
+	}
+
+	if err := ScanStruct(ms, &camelTestRow{}); err == nil {
+		t.Errorf("Expected no matching field without the transform")
+	}
+
+}
+
+func TestScanStructAlternateNames(t *testing.T) {
+
+	type renamed struct {
+		ID    string `sql:"id"`
+		Email string `sql:"email|email_address"`
+	}
+
+	for _, col := range []string{"email", "email_address"} {
+		ms := &MockRows{
+			ColumnsVal: []string{"id", col},
+			ScanImpl: func(vals ...interface{}) error {
+				*(vals[0].(*string)) = "1"
+				*(vals[1].(*string)) = "a@b.c"
+				return nil
+			},
+		}
+		row := &renamed{}
+		if err := ScanStruct(ms, row); err != nil {
+			t.Fatalf("%s: %s", col, err)
+		}
+		if row.Email != "a@b.c" {
+			t.Errorf("%s: expected to scan into Email, got %q", col, row.Email)
+		}
+	}
+
+	ms := &MockRows{
+		ColumnsVal: []string{"email", "email_address"},
+	}
+	if err := ScanStruct(ms, &renamed{}); err == nil {
+		t.Errorf("Expected error when both names are present")
+	}
+
+	names, err := StructColNames(&renamed{}, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Join(names, ",") != "id,email" {
+		t.Errorf("Expected only the first name to be selected, got %v", names)
+	}
+
+	user := &renamed{}
+	insert, err := InsertStructExcluding("users", nil, user)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compareSQL(t, insert, "INSERT INTO users (id,email) VALUES (?,?)", &user.ID, &user.Email)
+}
+
+type testStatus int
+
+const (
+	testStatusUnknown testStatus = iota
+	testStatusActive
+)
+
+func (ts *testStatus) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "active":
+		*ts = testStatusActive
+	default:
+		return fmt.Errorf("unknown status %q", text)
+	}
+	return nil
+}
+
+func TestScanStructTextUnmarshaler(t *testing.T) {
+
+	type withStatus struct {
+		ID     string     `sql:"id"`
+		Status testStatus `sql:"status"`
+	}
+
+	scanStatus := func(status interface{}) func(...interface{}) error {
+		return func(vals ...interface{}) error {
+			*(vals[0].(*string)) = "1"
+			return vals[1].(sql.Scanner).Scan(status)
+		}
+	}
+
+	ms := &MockRows{
+		ColumnsVal: []string{"id", "status"},
+		ScanImpl:   scanStatus([]byte("active")),
+	}
+	row := &withStatus{}
+	if err := ScanStruct(ms, row); err != nil {
+		t.Fatal(err.Error())
+	}
+	if row.Status != testStatusActive {
+		t.Errorf("Expected active, got %d", row.Status)
+	}
+
+	// Integer columns scan into the int the enum is backed by, as they do
+	// without UnmarshalText
+	ms.ScanImpl = scanStatus(int64(1))
+	row = &withStatus{}
+	if err := ScanStruct(ms, row); err != nil {
+		t.Fatal(err.Error())
+	}
+	if row.Status != testStatusActive {
+		t.Errorf("Expected active from an integer, got %d", row.Status)
+	}
+
+	for _, status := range []interface{}{"deleted", nil, float64(1.5), true} {
+		ms.ScanImpl = scanStatus(status)
+		if err := ScanStruct(ms, &withStatus{}); err == nil {
+			t.Errorf("Expected error scanning %v", status)
+		}
+	}
+}
+
+func TestStructValueErrors(t *testing.T) {
+
+	var nilRow *simpleTestRow
+	var nilIface interface{}
+	str := ""
+
+	for _, tc := range []struct {
+		name   string
+		dest   interface{}
+		expect string
+	}{
+		{name: "nil", dest: nil, expect: "got nil"},
+		{name: "struct", dest: simpleTestRow{}, expect: "got sqrlx.simpleTestRow (kind struct)"},
+		{name: "string", dest: "string", expect: "got string (kind string)"},
+		{name: "pointer to string", dest: &str, expect: "got *string (pointer to string)"},
+		{name: "nil pointer", dest: nilRow, expect: "got a nil pointer to struct sqrlx.simpleTestRow"},
+		{name: "nil interface", dest: &nilIface, expect: "got *interface {} holding nil"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := StructColNames(tc.dest, "")
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), "StructColNames requires a pointer to a struct") || !strings.Contains(err.Error(), tc.expect) {
+				t.Errorf("Expected error containing %q, got %q", tc.expect, err)
+			}
+
+			if _, err := InsertStruct("table", tc.dest); err == nil {
+				t.Errorf("Expected an InsertStruct error")
+			}
+		})
+	}
+
+	// A pointer to an interface holding a pointer to a struct is followed
+	var dest interface{} = &simpleTestRow{}
+	ms := &MockRows{
+		ColumnsVal: []string{"id"},
+		ScanImpl: func(vals ...interface{}) error {
+			*(vals[0].(*string)) = "1"
+			return nil
+		},
+	}
+	if err := ScanStruct(ms, &dest); err != nil {
+		t.Fatal(err.Error())
+	}
+	if dest.(*simpleTestRow).ID != "1" {
+		t.Errorf("Expected to scan through the interface")
+	}
+}
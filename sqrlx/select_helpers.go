@@ -0,0 +1,175 @@
+package sqrlx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SelectColumn runs a query returning exactly one column, and scans the value
+// of each row into a T. The query runs with Select, so transient errors are
+// retried. No rows results in an empty slice, not sql.ErrNoRows.
+func SelectColumn[T any](ctx context.Context, q Commander, bb Sqlizer) ([]T, error) {
+	rows, err := q.Select(ctx, bb)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("getting columns: %w", err)
+	}
+	if len(cols) != 1 {
+		return nil, fmt.Errorf("SelectColumn requires exactly one column, got %d (%s)", len(cols), strings.Join(cols, ", "))
+	}
+
+	values := []T{}
+	for rows.Next() {
+		var value T
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, rows.Close()
+}
+
+// SelectMap runs a query returning exactly two columns, and scans each row
+// into a map from the first column to the second. The query runs with Select,
+// so transient errors are retried. A key returned more than once is an error,
+// rather than one value silently replacing another.
+func SelectMap[K comparable, V any](ctx context.Context, q Commander, bb Sqlizer) (map[K]V, error) {
+	rows, err := q.Select(ctx, bb)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("getting columns: %w", err)
+	}
+	if len(cols) != 2 {
+		return nil, fmt.Errorf("SelectMap requires exactly two columns, got %d (%s)", len(cols), strings.Join(cols, ", "))
+	}
+
+	values := map[K]V{}
+	for rows.Next() {
+		var key K
+		var value V
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("SelectMap got duplicate key %v in column %s", key, cols[0])
+		}
+		values[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, rows.Close()
+}
+
+// QueryStructs runs a query with Select, so transient errors are retried, and
+// scans every row into a T, which can be any struct with sql tags including an
+// anonymous struct.
+func QueryStructs[T any](ctx context.Context, q Commander, bb Sqlizer) ([]T, error) {
+	rows, err := q.Select(ctx, bb)
+	if err != nil {
+		return nil, err
+	}
+	return ScanAll[T](rows)
+}
+
+// ScanAll scans every row into a T, which must be a struct with sql tags, and
+// closes the rows. Scanning stops at the first error. When limited by
+// WithMaxRows, the rows up to the limit are returned with ErrTooManyRows,
+// other errors return no rows.
+func ScanAll[T any](rows *Rows, options ...ScanOption) ([]T, error) {
+	defer rows.Close()
+
+	opts := newScanOptions(options)
+	values := []T{}
+	for rows.Next() {
+		if err := opts.checkRow(len(values)); err != nil {
+			return values, err
+		}
+		var value T
+		if err := ScanStruct(rows, &value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, rows.Close()
+}
+
+// ScanAllBestEffort is ScanAll, but continues past rows which fail to scan. It
+// returns the rows which scanned successfully along with the errors of any
+// which did not, joined with errors.Join. Errors from the underlying rows still
+// stop iteration.
+func ScanAllBestEffort[T any](rows *Rows) ([]T, error) {
+	defer rows.Close()
+
+	values := []T{}
+	var scanErrors []error
+	for idx := 0; rows.Next(); idx++ {
+		var value T
+		if err := ScanStruct(rows, &value); err != nil {
+			scanErrors = append(scanErrors, fmt.Errorf("row %d: %w", idx, err))
+			continue
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		scanErrors = append(scanErrors, err)
+	}
+	if err := rows.Close(); err != nil {
+		scanErrors = append(scanErrors, err)
+	}
+	return values, errors.Join(scanErrors...)
+}
+
+// ScanBatches scans rows into a T, as ScanAll, calling fn with each batch of
+// up to size rows, including a final partial batch, then closes the rows. The
+// slice passed to fn is not reused. Scanning stops at the first error from a
+// scan or from fn.
+func ScanBatches[T any](rows *Rows, size int, fn func([]T) error) error {
+	defer rows.Close()
+
+	if size < 1 {
+		return fmt.Errorf("ScanBatches requires a positive batch size, got %d", size)
+	}
+
+	batch := make([]T, 0, size)
+	for rows.Next() {
+		var value T
+		if err := ScanStruct(rows, &value); err != nil {
+			return err
+		}
+		batch = append(batch, value)
+		if len(batch) == size {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = make([]T, 0, size)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+	return rows.Close()
+}
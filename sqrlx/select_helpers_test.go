@@ -0,0 +1,469 @@
+package sqrlx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSelectColumn(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Happy", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+		ids, err := SelectColumn[int64](ctx, tx, testSqlizer{str: "SELECT id FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+			t.Errorf("Unexpected ids %v", ids)
+		}
+	})
+
+	t.Run("No Rows", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		ids, err := SelectColumn[string](ctx, tx, testSqlizer{str: "SELECT id FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if ids == nil || len(ids) != 0 {
+			t.Errorf("Expected empty slice, got %v", ids)
+		}
+	})
+
+	t.Run("Multiple Columns", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id, name FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "a"))
+
+		if _, err := SelectColumn[string](ctx, tx, testSqlizer{str: "SELECT id, name FROM b"}); err == nil {
+			t.Errorf("Expected error for multiple columns")
+		}
+	})
+}
+
+func TestSelectMap(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Happy", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT key, value FROM settings").
+			WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("a", "1").AddRow("b", "2"))
+
+		settings, err := SelectMap[string, string](ctx, tx, testSqlizer{str: "SELECT key, value FROM settings"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(settings) != 2 || settings["a"] != "1" || settings["b"] != "2" {
+			t.Errorf("Unexpected map %v", settings)
+		}
+	})
+
+	t.Run("Duplicate Key", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT key, value FROM settings").
+			WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("a", "1").AddRow("a", "2"))
+
+		if _, err := SelectMap[string, string](ctx, tx, testSqlizer{str: "SELECT key, value FROM settings"}); err == nil {
+			t.Errorf("Expected error for duplicate key")
+		}
+	})
+
+	t.Run("One Column", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT key FROM settings").
+			WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("a"))
+
+		if _, err := SelectMap[string, string](ctx, tx, testSqlizer{str: "SELECT key FROM settings"}); err == nil {
+			t.Errorf("Expected error for one column")
+		}
+	})
+}
+
+type selectTestRow struct {
+	ID   int64  `sql:"id"`
+	Name string `sql:"name"`
+}
+
+func TestScanAll(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Strict", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id, name FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+				AddRow(1, "a").
+				AddRow(2, nil).
+				AddRow(3, "c"))
+
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id, name FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := ScanAll[selectTestRow](rows); err == nil {
+			t.Fatal("Expected error scanning NULL into string")
+		}
+	})
+
+	t.Run("Best Effort", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id, name FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+				AddRow(1, "a").
+				AddRow(2, nil).
+				AddRow(3, "c"))
+
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id, name FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		values, err := ScanAllBestEffort[selectTestRow](rows)
+		if err == nil {
+			t.Fatal("Expected error scanning NULL into string")
+		}
+		if !strings.Contains(err.Error(), "row 1") {
+			t.Errorf("Error should name the failed row: %s", err)
+		}
+		if len(values) != 2 || values[0].ID != 1 || values[1].ID != 3 {
+			t.Errorf("Unexpected values %v", values)
+		}
+	})
+	t.Run("Max Rows", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id, name FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+				AddRow(1, "a").
+				AddRow(2, "b").
+				AddRow(3, "c"))
+
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id, name FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		vals, err := ScanAll[selectTestRow](rows, WithMaxRows(2))
+		if !errors.Is(err, ErrTooManyRows) {
+			t.Errorf("Expected ErrTooManyRows, got %v", err)
+		}
+		// The rows up to the limit are kept
+		if len(vals) != 2 || vals[0].ID != 1 || vals[1].ID != 2 {
+			t.Errorf("Expected the first 2 rows, got %v", vals)
+		}
+	})
+
+	t.Run("Max Rows Not Exceeded", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id, name FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+				AddRow(1, "a").
+				AddRow(2, "b"))
+
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id, name FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		values, err := ScanAll[selectTestRow](rows, WithMaxRows(2))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(values) != 2 {
+			t.Errorf("Expected 2 values, got %d", len(values))
+		}
+	})
+}
+
+func TestQueryStructs(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT b.id, c.name FROM b JOIN c").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+			AddRow(1, "a").
+			AddRow(2, "b"))
+
+	values, err := QueryStructs[struct {
+		ID   int64  `sql:"id"`
+		Name string `sql:"name"`
+	}](ctx, tx, testSqlizer{str: "SELECT b.id, c.name FROM b JOIN c"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(values) != 2 || values[0].Name != "a" || values[1].ID != 2 {
+		t.Errorf("Unexpected values %v", values)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestRowsEachInto(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT id, name FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+			AddRow(1, "a").
+			AddRow(2, "b"))
+
+	rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id, name FROM b"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var row selectTestRow
+	seen := []selectTestRow{}
+	if err := rows.EachInto(&row, func() error {
+		seen = append(seen, row)
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(seen) != 2 || seen[0].ID != 1 || seen[0].Name != "a" || seen[1].ID != 2 || seen[1].Name != "b" {
+		t.Errorf("Unexpected rows %v", seen)
+	}
+
+	if err := rows.EachInto(row, func() error { return nil }); err == nil {
+		t.Errorf("Expected error for non pointer dest")
+	}
+}
+
+func TestRowsEachCounted(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Rows", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		stopErr := testError("stop")
+		count, err := rows.EachCounted(func(row Scannable) error {
+			var id int64
+			if err := row.Scan(&id); err != nil {
+				return err
+			}
+			if id == 3 {
+				return stopErr
+			}
+			return nil
+		})
+		if err != stopErr {
+			t.Errorf("Expected stop error, got %v", err)
+		}
+		if count != 2 {
+			t.Errorf("Expected 2 rows, got %d", count)
+		}
+	})
+
+	t.Run("No Rows", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		count, err := rows.EachCounted(func(row Scannable) error {
+			return nil
+		})
+		if err != nil || count != 0 {
+			t.Errorf("Expected (0, nil), got (%d, %v)", count, err)
+		}
+	})
+	t.Run("Max Rows", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT id FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id FROM b"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		count, err := rows.EachCounted(func(row Scannable) error {
+			return nil
+		}, WithMaxRows(2))
+		if !errors.Is(err, ErrTooManyRows) {
+			t.Errorf("Expected ErrTooManyRows, got %v", err)
+		}
+		if count != 2 {
+			t.Errorf("Expected 2 rows, got %d", count)
+		}
+	})
+}
+
+func TestRowScanStructNoRows(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT id, name FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	row := selectTestRow{}
+	err := tx.SelectRow(ctx, testSqlizer{str: "SELECT id, name FROM b"}).ScanStruct(&row)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected ErrNoRows, got %v", err)
+	}
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected ErrNoRows to be returned unwrapped, got %v", err)
+	}
+}
+
+func TestRowScanRawBytes(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT data FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow([]byte("payload")))
+
+	var data sql.RawBytes
+	if err := tx.SelectRow(ctx, testSqlizer{str: "SELECT data FROM b"}).Scan(&data); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The rows are closed by Scan, the data must have been copied
+	if string(data) != "payload" {
+		t.Errorf("Expected payload, got %q", string(data))
+	}
+}
+
+func TestScanBatches(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT id, name FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+			AddRow(1, "a").
+			AddRow(2, "b").
+			AddRow(3, "c").
+			AddRow(4, "d").
+			AddRow(5, "e"))
+
+	rows, err := tx.Select(ctx, testSqlizer{str: "SELECT id, name FROM b"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	sizes := []int{}
+	total := int64(0)
+	if err := ScanBatches(rows, 2, func(batch []selectTestRow) error {
+		sizes = append(sizes, len(batch))
+		for _, row := range batch {
+			total += row.ID
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Errorf("Expected batches of 2, 2, 1, got %v", sizes)
+	}
+	if total != 15 {
+		t.Errorf("Expected every row, got total %d", total)
+	}
+}
+
+func TestScanStructNullError(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT id, name FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, nil))
+
+	row := selectTestRow{}
+	err := tx.SelectRow(ctx, testSqlizer{str: "SELECT id, name FROM b"}).ScanStruct(&row)
+	if err == nil {
+		t.Fatal("Expected error scanning NULL into string")
+	}
+	if !strings.Contains(err.Error(), "scanning column name into field of type string") {
+		t.Errorf("Expected error to name the column and type, got %s", err)
+	}
+	if !strings.Contains(err.Error(), "NULL values need a pointer") {
+		t.Errorf("Expected a hint for the NULL value, got %s", err)
+	}
+}
+
+func TestScanStructConversionError(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT id, name FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow("abc", "a"))
+
+	row := selectTestRow{}
+	err := tx.SelectRow(ctx, testSqlizer{str: "SELECT id, name FROM b"}).ScanStruct(&row)
+	if err == nil {
+		t.Fatal("Expected error scanning text into int64")
+	}
+	if !strings.Contains(err.Error(), "scanning column id into field of type int64") {
+		t.Errorf("Expected error to name the column and type, got %s", err)
+	}
+	if strings.Contains(err.Error(), "NULL") {
+		t.Errorf("Expected no NULL hint for a non NULL value, got %s", err)
+	}
+
+	// Errors which don't name a column index are returned as they are
+	scanErr := errors.New("connection reset")
+	ms := &MockRows{
+		ColumnsVal: []string{"id", "name"},
+		ScanImpl: func(vals ...interface{}) error {
+			return scanErr
+		},
+	}
+	if err := ScanStruct(ms, &selectTestRow{}); err != scanErr {
+		t.Errorf("Expected the scan error unchanged, got %v", err)
+	}
+}
+
+func TestRowScanDestinationCount(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery("SELECT id, name FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "a"))
+
+	var id int64
+	err := tx.SelectRow(ctx, testSqlizer{str: "SELECT id, name FROM b"}).Scan(&id)
+	if err == nil {
+		t.Fatal("Expected error for destination count mismatch")
+	}
+	if !strings.Contains(err.Error(), "row has 2 columns (id, name), scanning into 1 destinations") {
+		t.Errorf("Expected error to list the columns, got %s", err)
+	}
+}
@@ -7,27 +7,38 @@ import (
 	sq "github.com/elgris/sqrl"
 )
 
+// InsertStruct builds an insert of one row per src, each a pointer to a struct
+// with sql tags. A single slice of structs or struct pointers may be passed in
+// place of the variadic srcs.
 func InsertStruct(table string, srcs ...interface{}) (*sq.InsertBuilder, error) {
+	return InsertStructOpts(table, StructOptions{}, srcs...)
+}
+
+// InsertStructOpts is InsertStruct, with the columns of each src modified by
+// opts
+func InsertStructOpts(table string, opts StructOptions, srcs ...interface{}) (*sq.InsertBuilder, error) {
 
 	builder := sq.Insert(table)
 
+	if len(srcs) == 1 {
+		srcs = expandSlice(srcs[0], srcs)
+	}
+
 	names := make([]string, 0)
 
 	for idx, src := range srcs {
 
-		rv := reflect.ValueOf(src)
-		if rv.Kind() != reflect.Ptr {
-			return nil, fmt.Errorf("InsertStruct requires a pointer to a struct")
-		}
-		rv = rv.Elem()
-		if rv.Kind() != reflect.Struct {
-			return nil, fmt.Errorf("InsertStruct requires a pointer to a struct")
+		rv, err := structValue("InsertStruct", src)
+		if err != nil {
+			return nil, err
 		}
 
 		structCols := map[string]interface{}{}
 
 		if err := addNamed(&walkBaton{
 			structCols: structCols,
+			transform:  opts.ColumnTransform,
+			forWrite:   true,
 		}, rv); err != nil {
 			return nil, err
 		}
@@ -54,17 +65,31 @@ func InsertStruct(table string, srcs ...interface{}) (*sq.InsertBuilder, error)
 
 }
 
+// expandSlice returns the elements of src as pointers if it is a slice,
+// otherwise the default.
+func expandSlice(src interface{}, def []interface{}) []interface{} {
+	rv := reflect.ValueOf(src)
+	if rv.Kind() != reflect.Slice {
+		return def
+	}
+	expanded := make([]interface{}, rv.Len())
+	for idx := range expanded {
+		elem := rv.Index(idx)
+		if elem.Kind() == reflect.Struct {
+			elem = elem.Addr()
+		}
+		expanded[idx] = elem.Interface()
+	}
+	return expanded
+}
+
 func UpdateStruct(table string, src interface{}) (*sq.UpdateBuilder, error) {
 
 	builder := sq.Update(table)
 
-	rv := reflect.ValueOf(src)
-	if rv.Kind() != reflect.Ptr {
-		return nil, fmt.Errorf("UpdateStruct requires a pointer to a struct")
-	}
-	rv = rv.Elem()
-	if rv.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("UpdateStruct requires a pointer to a struct")
+	rv, err := structValue("UpdateStruct", src)
+	if err != nil {
+		return nil, err
 	}
 
 	structCols := map[string]interface{}{}
@@ -72,6 +97,7 @@ func UpdateStruct(table string, src interface{}) (*sq.UpdateBuilder, error) {
 	if err := addNamed(&walkBaton{
 		structCols: structCols,
 		override:   true,
+		forWrite:   true,
 	}, rv); err != nil {
 		return nil, err
 	}
@@ -81,3 +107,110 @@ func UpdateStruct(table string, src interface{}) (*sq.UpdateBuilder, error) {
 	}
 	return builder, nil
 }
+
+// DeleteStruct builds a delete of the row identified by the current values of
+// keyColumns in src, or of the fields tagged pk when no columns are given. It
+// is an error if there are no key columns, rather than deleting every row.
+func DeleteStruct(table string, src interface{}, keyColumns ...string) (*sq.DeleteBuilder, error) {
+	rv, err := structValue("DeleteStruct", src)
+	if err != nil {
+		return nil, err
+	}
+
+	structCols := map[string]interface{}{}
+	pkCols := []string{}
+	if err := addNamed(&walkBaton{
+		structCols: structCols,
+		override:   true,
+		keyCols:    &pkCols,
+	}, rv); err != nil {
+		return nil, err
+	}
+
+	if len(keyColumns) == 0 {
+		keyColumns = pkCols
+	}
+	if len(keyColumns) == 0 {
+		return nil, fmt.Errorf("DeleteStruct: no key columns for %s", rv.Type())
+	}
+
+	builder := sq.Delete(table)
+	for _, column := range keyColumns {
+		value, ok := structCols[column]
+		if !ok {
+			return nil, fmt.Errorf("DeleteStruct: no struct field for column %s", column)
+		}
+		builder = builder.Where(column+" = ?", reflect.ValueOf(value).Elem().Interface())
+	}
+	return builder, nil
+}
+
+// InsertStructColumns is InsertStruct for a single src, inserting only the
+// named columns, in the given order. Naming a column which the struct does not
+// have is an error.
+func InsertStructColumns(table string, columns []string, src interface{}) (*sq.InsertBuilder, error) {
+	structCols, _, err := orderedStructCols("InsertStructColumns", src)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		value, ok := structCols[column]
+		if !ok {
+			return nil, fmt.Errorf("InsertStructColumns: no struct field for column %s", column)
+		}
+		values = append(values, value)
+	}
+
+	return sq.Insert(table).Columns(columns...).Values(values...), nil
+}
+
+// InsertStructExcluding is InsertStruct for a single src, inserting every
+// column except those named, in declaration order. Naming a column which the
+// struct does not have is an error.
+func InsertStructExcluding(table string, excluded []string, src interface{}) (*sq.InsertBuilder, error) {
+	structCols, order, err := orderedStructCols("InsertStructExcluding", src)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[string]struct{}, len(excluded))
+	for _, column := range excluded {
+		if _, ok := structCols[column]; !ok {
+			return nil, fmt.Errorf("InsertStructExcluding: no struct field for column %s", column)
+		}
+		skip[column] = struct{}{}
+	}
+
+	columns := make([]string, 0, len(order))
+	values := make([]interface{}, 0, len(order))
+	for _, column := range order {
+		if _, ok := skip[column]; ok {
+			continue
+		}
+		columns = append(columns, column)
+		values = append(values, structCols[column])
+	}
+
+	return sq.Insert(table).Columns(columns...).Values(values...), nil
+}
+
+// orderedStructCols returns the columns of src, and their declaration order
+func orderedStructCols(funcName string, src interface{}) (map[string]interface{}, []string, error) {
+	rv, err := structValue(funcName, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	structCols := map[string]interface{}{}
+	order := []string{}
+	if err := addNamed(&walkBaton{
+		structCols: structCols,
+		colOrder:   &order,
+		forWrite:   true,
+	}, rv); err != nil {
+		return nil, nil, err
+	}
+	return structCols, order, nil
+}
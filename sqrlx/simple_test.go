@@ -0,0 +1,198 @@
+package sqrlx
+
+import (
+	"strings"
+	"testing"
+)
+
+type simpleTestRow struct {
+	ID string `sql:"id"`
+}
+
+func TestInsertStructSlice(t *testing.T) {
+
+	b, err := InsertStruct("table", []*simpleTestRow{{ID: "a"}, {ID: "b"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	stmt, args, err := b.ToSql()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if stmt != "INSERT INTO table (id) VALUES (?),(?)" {
+		t.Errorf("Unexpected statement %s", stmt)
+	}
+	if len(args) != 2 || *(args[0].(*string)) != "a" || *(args[1].(*string)) != "b" {
+		t.Errorf("Unexpected args %v", args)
+	}
+
+	if _, err := InsertStruct("table", []simpleTestRow{{ID: "a"}}); err != nil {
+		t.Errorf("Expected slice of structs to be accepted: %s", err)
+	}
+
+	if _, err := InsertStruct("table", []string{"a"}); err == nil {
+		t.Errorf("Expected error for slice of non-structs")
+	}
+
+}
+
+type simpleTestUser struct {
+	ID    string `sql:"id"`
+	Name  string `sql:"name"`
+	Email string `sql:"email"`
+}
+
+func TestInsertStructColumns(t *testing.T) {
+
+	user := &simpleTestUser{ID: "1", Name: "A", Email: "a@b.c"}
+
+	b, err := InsertStructColumns("users", []string{"email", "name"}, user)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compareSQL(t, b, "INSERT INTO users (email,name) VALUES (?,?)", &user.Email, &user.Name)
+
+	if _, err := InsertStructColumns("users", []string{"missing"}, user); err == nil {
+		t.Errorf("Expected error for unknown column")
+	}
+
+}
+
+func TestInsertStructExcluding(t *testing.T) {
+
+	user := &simpleTestUser{ID: "1", Name: "A", Email: "a@b.c"}
+
+	b, err := InsertStructExcluding("users", []string{"id"}, user)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compareSQL(t, b, "INSERT INTO users (name,email) VALUES (?,?)", &user.Name, &user.Email)
+
+	if _, err := InsertStructExcluding("users", []string{"missing"}, user); err == nil {
+		t.Errorf("Expected error for unknown column")
+	}
+
+}
+
+type simpleTestKeyed struct {
+	TenantID string `sql:"tenant_id,pk"`
+	ID       string `sql:"id,pk"`
+	Name     string `sql:"name"`
+}
+
+func TestDeleteStruct(t *testing.T) {
+
+	row := &simpleTestKeyed{TenantID: "t", ID: "1", Name: "A"}
+
+	b, err := DeleteStruct("things", row)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compareSQL(t, b, "DELETE FROM things WHERE tenant_id = ? AND id = ?", "t", "1")
+
+	b, err = DeleteStruct("users", &simpleTestUser{ID: "1", Email: "a@b.c"}, "email")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compareSQL(t, b, "DELETE FROM users WHERE email = ?", "a@b.c")
+
+	if _, err := DeleteStruct("users", &simpleTestUser{ID: "1"}); err == nil {
+		t.Errorf("Expected error for struct without key columns")
+	}
+
+	if _, err := DeleteStruct("users", &simpleTestUser{ID: "1"}, "missing"); err == nil {
+		t.Errorf("Expected error for unknown column")
+	}
+
+}
+
+func snakeCase(name string) string {
+	out := make([]rune, 0, len(name))
+	for _, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			out = append(out, '_', r-'A'+'a')
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+type camelTestRow struct {
+	UserID string `sql:"userId"`
+}
+
+func TestInsertStructOpts(t *testing.T) {
+
+	row := &camelTestRow{UserID: "1"}
+
+	b, err := InsertStructOpts("users", StructOptions{
+		ColumnTransform: snakeCase,
+	}, row)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compareSQL(t, b, "INSERT INTO users (user_id) VALUES (?)", &row.UserID)
+
+	// Without options, tags are used unchanged
+	b, err = InsertStruct("users", row)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compareSQL(t, b, "INSERT INTO users (userId) VALUES (?)", &row.UserID)
+
+}
+
+func TestStructReadonly(t *testing.T) {
+
+	type document struct {
+		ID           string `sql:"id"`
+		Body         string `sql:"body"`
+		SearchVector string `sql:"search_vector,readonly"`
+	}
+
+	doc := &document{ID: "1", Body: "text"}
+
+	insert, err := InsertStruct("documents", doc)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	stmt, _, err := insert.ToSql()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if stmt != "INSERT INTO documents (id,body) VALUES (?,?)" && stmt != "INSERT INTO documents (body,id) VALUES (?,?)" {
+		t.Errorf("Expected readonly column to be skipped, got %s", stmt)
+	}
+
+	update, err := UpdateStruct("documents", doc)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	stmt, _, err = update.ToSql()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Contains(stmt, "search_vector") {
+		t.Errorf("Expected readonly column to be skipped, got %s", stmt)
+	}
+
+	// Still read
+	ms := &MockRows{
+		ColumnsVal: []string{"id", "search_vector"},
+		ScanImpl: func(vals ...interface{}) error {
+			*(vals[0].(*string)) = "1"
+			*(vals[1].(*string)) = "'text':1"
+			return nil
+		},
+	}
+	read := &document{}
+	if err := ScanStruct(ms, read); err != nil {
+		t.Fatal(err.Error())
+	}
+	if read.SearchVector != "'text':1" {
+		t.Errorf("Expected readonly column to be scanned, got %q", read.SearchVector)
+	}
+
+}
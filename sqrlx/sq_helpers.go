@@ -2,27 +2,179 @@ package sqrlx
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/elgris/sqrl"
 )
 
 var (
 	Question = sqrl.Question
-	Dollar   = sqrl.Dollar
+
+	// Dollar replaces ? placeholders with $1, $2 etc. as sqrl.Dollar does,
+	// but passes statements which already use $N placeholders through
+	// unchanged, other than unescaping ??. Statements mixing both are an
+	// error.
+	Dollar PlaceholderFormat = dollarFormat{}
 )
 
+type dollarFormat struct{}
+
+// isDollar returns true for Dollar, and for sqrl.Dollar passed directly
+func isDollar(format PlaceholderFormat) bool {
+	return format == Dollar || format == sqrl.Dollar
+}
+
+func (dollarFormat) ReplacePlaceholders(statement string) (string, error) {
+	hasQuestion, hasDollar := false, false
+	for _, ph := range findPlaceholders(statement) {
+		if ph.number > 0 {
+			hasDollar = true
+		} else {
+			hasQuestion = true
+		}
+	}
+
+	if hasDollar {
+		if hasQuestion {
+			return "", fmt.Errorf("statement mixes ? and $N placeholders")
+		}
+		return strings.ReplaceAll(statement, "??", "?"), nil
+	}
+	return sqrl.Dollar.ReplacePlaceholders(statement)
+}
+
+// placeholder is a ? or $N placeholder in a statement
+type placeholder struct {
+	// start and end are byte offsets of the placeholder in the statement
+	start, end int
+
+	// number is N for $N, zero for ?
+	number int
+}
+
+// findPlaceholders returns the placeholders of statement, skipping escaped
+// ??, and anything within quoted strings or identifiers, comments and dollar
+// quoted bodies, so `price = '$5'` or a $1 within a $$ function body are not
+// taken as placeholders.
+func findPlaceholders(statement string) []placeholder {
+	found := []placeholder{}
+	skipTo := func(from int, terminator string) int {
+		end := strings.Index(statement[from:], terminator)
+		if end < 0 {
+			return len(statement)
+		}
+		return from + end + len(terminator)
+	}
+
+	for idx := 0; idx < len(statement); {
+		char := statement[idx]
+		next := byte(0)
+		if idx+1 < len(statement) {
+			next = statement[idx+1]
+		}
+
+		switch {
+		case char == '\'':
+			// Doubled '' escapes end and restart the string, so need no
+			// special handling
+			idx = skipTo(idx+1, "'")
+		case char == '"':
+			idx = skipTo(idx+1, `"`)
+		case char == '-' && next == '-':
+			idx = skipTo(idx+2, "\n")
+		case char == '/' && next == '*':
+			idx = skipTo(idx+2, "*/")
+		case char == '?' && next == '?':
+			idx += 2
+		case char == '?':
+			found = append(found, placeholder{start: idx, end: idx + 1})
+			idx++
+		case char == '$' && idx > 0 && isIdentChar(statement[idx-1]):
+			// $ within an identifier, e.g. a$1
+			idx++
+		case char == '$' && next >= '0' && next <= '9':
+			end := idx + 1
+			number := 0
+			for end < len(statement) && statement[end] >= '0' && statement[end] <= '9' {
+				number = number*10 + int(statement[end]-'0')
+				end++
+			}
+			found = append(found, placeholder{start: idx, end: end, number: number})
+			idx = end
+		case char == '$':
+			// $$ or $tag$ opens a dollar quoted body, closed by the same tag
+			end := idx + 1
+			for end < len(statement) && isIdentChar(statement[end]) {
+				end++
+			}
+			if end < len(statement) && statement[end] == '$' {
+				idx = skipTo(end+1, statement[idx:end+1])
+			} else {
+				idx = end
+			}
+		default:
+			idx++
+		}
+	}
+	return found
+}
+
+func isIdentChar(char byte) bool {
+	return char == '_' ||
+		(char >= 'a' && char <= 'z') ||
+		(char >= 'A' && char <= 'Z') ||
+		(char >= '0' && char <= '9') ||
+		char >= 0x80
+}
+
 type CaseSumBuilder struct {
 	Target    string
 	Condition string
 	Args      []interface{}
+
+	// Cond is used as the condition in place of Condition and Args, with its
+	// args spliced into the statement.
+	Cond sqrl.Sqlizer
+
+	// TargetArgs bind to placeholders in Target
+	TargetArgs []interface{}
+
+	// Filtered uses the Postgres aggregate FILTER clause in place of CASE WHEN.
+	// Leave false for databases which do not support FILTER.
+	Filtered bool
 }
 
 func (cs CaseSumBuilder) ToSql() (string, []interface{}, error) {
+	condition, condArgs := cs.Condition, cs.Args
+	if cs.Cond != nil {
+		if cs.Condition != "" || len(cs.Args) > 0 {
+			return "", nil, fmt.Errorf("case sum cannot have both Cond and Condition")
+		}
+		var err error
+		condition, condArgs, err = cs.Cond.ToSql()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	args := make([]interface{}, 0, len(condArgs)+len(cs.TargetArgs))
+	if cs.Filtered {
+		args = append(args, cs.TargetArgs...)
+		args = append(args, condArgs...)
+		return fmt.Sprintf(`COALESCE(SUM(COALESCE(%s,0)) FILTER (WHERE %s), 0)`,
+			cs.Target,
+			condition,
+		), args, nil
+	}
+
+	args = append(args, condArgs...)
+	args = append(args, cs.TargetArgs...)
 	return fmt.Sprintf(`COALESCE(SUM(CASE WHEN %s THEN COALESCE(%s,0) ELSE 0 END), 0)`,
-		cs.Condition,
+		condition,
 		cs.Target,
-	), cs.Args, nil
+	), args, nil
 }
 
 func CaseSum(target, condition string, args ...interface{}) *CaseSumBuilder {
@@ -33,6 +185,97 @@ func CaseSum(target, condition string, args ...interface{}) *CaseSumBuilder {
 	}
 }
 
+// CaseSumSq is CaseSum with the condition built from a Sqlizer
+func CaseSumSq(target string, cond sqrl.Sqlizer) *CaseSumBuilder {
+	return &CaseSumBuilder{
+		Target: target,
+		Cond:   cond,
+	}
+}
+
+// SumFilter is CaseSum using the Postgres FILTER clause
+func SumFilter(target, condition string, args ...interface{}) *CaseSumBuilder {
+	return &CaseSumBuilder{
+		Target:    target,
+		Condition: condition,
+		Args:      args,
+		Filtered:  true,
+	}
+}
+
+type rawSqlizer struct {
+	statement string
+	args      []interface{}
+}
+
+func (r rawSqlizer) ToSql() (string, []interface{}, error) {
+	return r.statement, r.args, nil
+}
+
+// Raw wraps a statement and args as a Sqlizer, so raw SQL can run through
+// Select, Query and Exec. Raw does not renumber placeholders itself, write the
+// statement with ? placeholders and the configured format replaces them.
+//
+// With WithSliceExpansion, a slice arg expands to one placeholder per
+// element, e.g. Raw("SELECT a FROM b WHERE id IN (?)", []int{1, 2}). For
+// Postgres, `id = ANY(?)` with pq.Array(ids) passes the slice as a single
+// array param instead, which keeps the statement the same for any number of
+// ids.
+func Raw(statement string, args ...interface{}) Sqlizer {
+	return rawSqlizer{
+		statement: statement,
+		args:      args,
+	}
+}
+
+// InClause builds `column IN (?,?,...)` with a placeholder per value, to
+// combine with sqrl's And and Or. An empty slice is `1=0`, which matches
+// nothing, rather than the invalid `IN ()`.
+func InClause[T any](column string, values []T) sqrl.Sqlizer {
+	if len(values) == 0 {
+		return rawSqlizer{statement: "1=0"}
+	}
+	args := make([]interface{}, len(values))
+	for idx, value := range values {
+		args[idx] = value
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+	return rawSqlizer{
+		statement: column + " IN (" + placeholders + ")",
+		args:      args,
+	}
+}
+
+type subQuery struct {
+	inner sqrl.Sqlizer
+	alias string
+}
+
+var numberedPlaceholder = regexp.MustCompile(`\$\d`)
+
+func (sq subQuery) ToSql() (string, []interface{}, error) {
+	innerSQL, args, err := sq.inner.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	if numberedPlaceholder.MatchString(innerSQL) {
+		return "", nil, fmt.Errorf("subquery %s has numbered placeholders, build it with ? placeholders", sq.alias)
+	}
+	return fmt.Sprintf("(%s) AS %s", innerSQL, sq.alias), args, nil
+}
+
+// SubQuery renders inner as `(inner) AS alias`, keeping the args of inner in
+// place. inner must use ? placeholders, the default, so they are numbered
+// along with the rest of the statement when it is rendered. sqrl's From only
+// accepts table names, so embed it with sqrl.Expr, e.g.
+// JoinClause(sqrl.Expr("JOIN ? ON sub.a_id = a.id", SubQuery(inner, "sub"))).
+func SubQuery(inner sqrl.Sqlizer, alias string) sqrl.Sqlizer {
+	return subQuery{
+		inner: inner,
+		alias: alias,
+	}
+}
+
 type Join []sqrl.Sqlizer
 
 func (parts Join) ToSql() (sql string, args []interface{}, err error) {
@@ -55,9 +298,107 @@ func (parts Join) ToSql() (sql string, args []interface{}, err error) {
 	return
 }
 
+type insertSelect struct {
+	into    string
+	columns []string
+	sel     sqrl.Sqlizer
+}
+
+func (is insertSelect) ToSql() (string, []interface{}, error) {
+	selectSQL, args, err := is.sel.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(is.columns) == 0 {
+		return fmt.Sprintf("INSERT INTO %s %s", is.into, selectSQL), args, nil
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) %s", is.into, strings.Join(is.columns, ","), selectSQL), args, nil
+}
+
+// InsertSelect builds `INSERT INTO into (columns) SELECT ...`, copying the rows
+// of selectBuilder, which must use ? placeholders (the sqrl default) so they
+// are numbered once for the whole statement. With no columns, the select must
+// return every column of the table in order.
+func InsertSelect(into string, columns []string, selectBuilder sqrl.Sqlizer) Sqlizer {
+	return insertSelect{
+		into:    into,
+		columns: columns,
+		sel:     selectBuilder,
+	}
+}
+
+type namedSqlizer struct {
+	name string
+	bb   Sqlizer
+}
+
+// CTEChain combines statements into a single statement with common table
+// expressions, `WITH a AS (...), b AS (...) <final>`, e.g. to run several
+// dependent upserts in one round trip. Args are concatenated in the order the
+// fragments appear. Fragments must use ? placeholders (the sqrl default) so
+// they are numbered once across the whole statement.
+type CTEChain struct {
+	ctes  []namedSqlizer
+	final Sqlizer
+}
+
+// NewCTEChain starts an empty chain, add fragments with With and the final
+// statement with Final
+func NewCTEChain() *CTEChain {
+	return &CTEChain{}
+}
+
+// With adds a named fragment, which later fragments and the final statement
+// can refer to by name. For INSERT, UPDATE and DELETE fragments, use RETURNING
+// to make their rows available.
+func (c *CTEChain) With(name string, bb Sqlizer) *CTEChain {
+	c.ctes = append(c.ctes, namedSqlizer{
+		name: name,
+		bb:   bb,
+	})
+	return c
+}
+
+// Final sets the statement following the WITH clause
+func (c *CTEChain) Final(bb Sqlizer) *CTEChain {
+	c.final = bb
+	return c
+}
+
+func (c CTEChain) ToSql() (string, []interface{}, error) {
+	if len(c.ctes) == 0 {
+		return "", nil, fmt.Errorf("CTEChain requires at least one fragment")
+	}
+	if c.final == nil {
+		return "", nil, fmt.Errorf("CTEChain requires a final statement")
+	}
+
+	parts := make([]string, 0, len(c.ctes))
+	args := []interface{}{}
+	for _, cte := range c.ctes {
+		cteSQL, cteArgs, err := cte.bb.ToSql()
+		if err != nil {
+			return "", nil, fmt.Errorf("CTE %s: %w", cte.name, err)
+		}
+		parts = append(parts, fmt.Sprintf("%s AS (%s)", cte.name, cteSQL))
+		args = append(args, cteArgs...)
+	}
+
+	finalSQL, finalArgs, err := c.final.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, finalArgs...)
+
+	return "WITH " + strings.Join(parts, ", ") + " " + finalSQL, args, nil
+}
+
 type fieldPair struct {
 	column string
 	value  interface{}
+
+	// conflict replaces column in the ON CONFLICT target when set
+	conflict string
 }
 
 type UpsertBuilder struct {
@@ -65,7 +406,42 @@ type UpsertBuilder struct {
 	keys []fieldPair
 	vals []fieldPair
 
-	updateStatement *sqrl.UpdateBuilder
+	constraint string
+
+	// updates replace the EXCLUDED copy of a column in the DO UPDATE clause
+	updates []updateExpr
+
+	wheres []whereClause
+
+	// returningInserted appends RETURNING (xmax = 0) AS inserted
+	returningInserted bool
+}
+
+type updateExpr struct {
+	column string
+	expr   string
+	args   []interface{}
+}
+
+type whereClause struct {
+	pred interface{}
+	args []interface{}
+}
+
+// ToSql follows sqrl's handling of Where predicates
+func (wc whereClause) ToSql() (string, []interface{}, error) {
+	switch pred := wc.pred.(type) {
+	case nil:
+		return "", nil, nil
+	case sqrl.Sqlizer:
+		return pred.ToSql()
+	case map[string]interface{}:
+		return sqrl.Eq(pred).ToSql()
+	case string:
+		return pred, wc.args, nil
+	default:
+		return "", nil, fmt.Errorf("expected string-keyed map or string, not %T", pred)
+	}
 }
 
 func (b UpsertBuilder) ToSql() (sqlStr string, args []interface{}, err error) {
@@ -74,7 +450,11 @@ func (b UpsertBuilder) ToSql() (sqlStr string, args []interface{}, err error) {
 		err = fmt.Errorf("upsert statements must specify a table")
 		return
 	}
-	if len(b.keys) == 0 {
+	if b.constraint != "" && len(b.keys) > 0 {
+		err = fmt.Errorf("upsert statements cannot have both keys and a constraint")
+		return
+	}
+	if b.constraint == "" && len(b.keys) == 0 {
 		err = fmt.Errorf("upsert statements must have at least one key")
 		return
 	}
@@ -87,53 +467,111 @@ func (b UpsertBuilder) ToSql() (sqlStr string, args []interface{}, err error) {
 
 	columns := make([]string, 0, len(b.keys)+len(b.vals))
 	values := make([]interface{}, 0, len(columns))
-	setMap := map[string]struct{}{}
+	// column to whether it was added as a key or a value
+	setMap := map[string]string{}
+
+	setList := make([]string, 0, len(b.vals)+len(b.updates))
+	suffixArgs := []interface{}{}
 
-	updateStatement := b.updateStatement
+	updates := make(map[string]updateExpr, len(b.updates))
+	for _, update := range b.updates {
+		if _, ok := updates[update.column]; ok {
+			err = fmt.Errorf("duplicate update expression for column %s", update.column)
+			return
+		}
+		updates[update.column] = update
+	}
 
+	// Stops at the first duplicate, so the error names the first column
 	for _, key := range b.keys {
-		if _, ok := setMap[key.column]; ok {
-			err = fmt.Errorf("duplicate column in keys and values: %s", key.column)
+		if existing, ok := setMap[key.column]; ok {
+			err = duplicateColumn(key.column, existing, "key")
 			return
 		}
-		setMap[key.column] = struct{}{}
+		if _, ok := updates[key.column]; ok {
+			err = fmt.Errorf("update expression for key column %s", key.column)
+			return
+		}
+		setMap[key.column] = "key"
 		columns = append(columns, key.column)
 		values = append(values, key.value)
-		keyList = append(keyList, key.column)
+		if key.conflict != "" {
+			keyList = append(keyList, key.conflict)
+		} else {
+			keyList = append(keyList, key.column)
+		}
 	}
 
 	for _, set := range b.vals {
-		if _, ok := setMap[set.column]; ok {
-			err = fmt.Errorf("duplicate column in keys and values: %s", set.column)
+		if existing, ok := setMap[set.column]; ok {
+			err = duplicateColumn(set.column, existing, "value")
 			return
 		}
-		setMap[set.column] = struct{}{}
+		setMap[set.column] = "value"
 		columns = append(columns, set.column)
 		values = append(values, set.value)
-		updateStatement.Set(set.column, sqrl.Expr(fmt.Sprintf("EXCLUDED.%s", set.column)))
+		if update, ok := updates[set.column]; ok {
+			setList = append(setList, fmt.Sprintf("%s = %s", set.column, update.expr))
+			suffixArgs = append(suffixArgs, update.args...)
+		} else {
+			setList = append(setList, fmt.Sprintf("%s = EXCLUDED.%s", set.column, set.column))
+		}
 	}
 
-	//	suffix := fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keyList, ","), strings.Join(valList, ", "))
-	updateString, suffixArgs, err := updateStatement.ToSql()
-	if err != nil {
-		return
+	// Columns which are only updated, not inserted
+	for _, update := range b.updates {
+		if _, ok := setMap[update.column]; ok {
+			continue
+		}
+		setList = append(setList, fmt.Sprintf("%s = %s", update.column, update.expr))
+		suffixArgs = append(suffixArgs, update.args...)
 	}
 
-	if updateString[0:9] != "UPDATE _ " {
-		err = fmt.Errorf("unexpected update string: %s", updateString[0:9])
-		return
+	updateString := "SET " + strings.Join(setList, ", ")
+
+	whereList := make([]string, 0, len(b.wheres))
+	for _, where := range b.wheres {
+		whereSQL, whereArgs, whereErr := where.ToSql()
+		if whereErr != nil {
+			err = whereErr
+			return
+		}
+		if whereSQL == "" {
+			continue
+		}
+		whereList = append(whereList, whereSQL)
+		suffixArgs = append(suffixArgs, whereArgs...)
+	}
+	if len(whereList) > 0 {
+		updateString += " WHERE " + strings.Join(whereList, " AND ")
 	}
 
-	updateString = fmt.Sprintf("ON CONFLICT (%s) DO UPDATE %s", strings.Join(keyList, ","), updateString[9:])
+	if b.constraint != "" {
+		updateString = fmt.Sprintf("ON CONFLICT ON CONSTRAINT %s DO UPDATE %s", b.constraint, updateString)
+	} else {
+		updateString = fmt.Sprintf("ON CONFLICT (%s) DO UPDATE %s", strings.Join(keyList, ","), updateString)
+	}
+
+	if b.returningInserted {
+		updateString += " RETURNING (xmax = 0) AS inserted"
+	}
 
 	return sqrl.Insert(b.into).Columns(columns...).Values(values...).Suffix(updateString, suffixArgs...).ToSql()
 
 }
 
+// duplicateColumn describes a column added to an upsert as role after it was
+// already added as existing
+func duplicateColumn(column, existing, role string) error {
+	if existing == role {
+		return fmt.Errorf("duplicate column in upsert: %s is added as a %s twice", column, role)
+	}
+	return fmt.Errorf("duplicate column in upsert: %s is both a %s and a %s", column, existing, role)
+}
+
 func Upsert(into string) *UpsertBuilder {
 	return &UpsertBuilder{
-		into:            into,
-		updateStatement: sqrl.Update("_"),
+		into: into,
 	}
 }
 
@@ -145,6 +583,18 @@ func (u *UpsertBuilder) Key(column string, value interface{}) *UpsertBuilder {
 	return u
 }
 
+// KeyExpr adds a key column whose conflict target is an expression, e.g.
+// lower(email) to match a functional unique index. The column and value are
+// inserted as with Key, only the ON CONFLICT list uses conflictExpr.
+func (u *UpsertBuilder) KeyExpr(column string, conflictExpr string, value interface{}) *UpsertBuilder {
+	u.keys = append(u.keys, fieldPair{
+		column:   column,
+		value:    value,
+		conflict: conflictExpr,
+	})
+	return u
+}
+
 func (u *UpsertBuilder) KeyMap(keys map[string]interface{}) *UpsertBuilder {
 	for k, v := range keys {
 		u.Key(k, v)
@@ -152,6 +602,14 @@ func (u *UpsertBuilder) KeyMap(keys map[string]interface{}) *UpsertBuilder {
 	return u
 }
 
+// OnConstraint uses the named constraint as the conflict target in place of the
+// key columns. All columns should be added with Set, as Key cannot be combined
+// with a constraint.
+func (u *UpsertBuilder) OnConstraint(name string) *UpsertBuilder {
+	u.constraint = name
+	return u
+}
+
 func (u *UpsertBuilder) Set(column string, value interface{}) *UpsertBuilder {
 	u.vals = append(u.vals, fieldPair{
 		column: column,
@@ -167,7 +625,78 @@ func (u *UpsertBuilder) SetMap(vals map[string]interface{}) *UpsertBuilder {
 	return u
 }
 
+// SetExpr updates column to expr in the DO UPDATE clause, in place of copying
+// the inserted value from EXCLUDED, e.g. to accumulate a counter with
+// SetExpr("count", "counter.count + EXCLUDED.count"). The column is still
+// inserted when added with Set, otherwise it is only updated. args are bound
+// to placeholders in expr.
+func (u *UpsertBuilder) SetExpr(column string, expr string, args ...interface{}) *UpsertBuilder {
+	u.updates = append(u.updates, updateExpr{
+		column: column,
+		expr:   expr,
+		args:   args,
+	})
+	return u
+}
+
+// ReturningInserted appends RETURNING (xmax = 0) AS inserted, which is true
+// when the row was inserted and false when an existing row was updated. This
+// relies on Postgres internals: xmax, the id of the deleting or locking
+// transaction, is zero for a newly inserted row version, while the row
+// version written by ON CONFLICT DO UPDATE holds a lock from the upserting
+// transaction. See Commander.UpsertReturningInserted.
+func (u *UpsertBuilder) ReturningInserted() *UpsertBuilder {
+	u.returningInserted = true
+	return u
+}
+
+// Where adds a condition to the DO UPDATE clause, accepting the same
+// predicates as sqrl's Where. Multiple conditions are joined with AND.
 func (u *UpsertBuilder) Where(pred interface{}, args ...interface{}) *UpsertBuilder {
-	u.updateStatement.Where(pred, args...)
+	u.wheres = append(u.wheres, whereClause{
+		pred: pred,
+		args: args,
+	})
 	return u
 }
+
+// CachedSqlizer memoizes the result of the wrapped Sqlizer's ToSql, including
+// any error, for builders rendered repeatedly in a hot path. It must only wrap
+// builders which are not modified after the first render, call Invalidate
+// after any change. The cached args are shared between calls and must not be
+// modified. It is safe for concurrent use.
+type CachedSqlizer struct {
+	bb Sqlizer
+
+	lock      sync.Mutex
+	rendered  bool
+	statement string
+	args      []interface{}
+	err       error
+}
+
+// Cache wraps bb in a CachedSqlizer
+func Cache(bb Sqlizer) *CachedSqlizer {
+	return &CachedSqlizer{
+		bb: bb,
+	}
+}
+
+func (cs *CachedSqlizer) ToSql() (string, []interface{}, error) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if !cs.rendered {
+		cs.statement, cs.args, cs.err = cs.bb.ToSql()
+		cs.rendered = true
+	}
+	return cs.statement, cs.args, cs.err
+}
+
+// Invalidate clears the cached result, the next ToSql renders the builder
+// again
+func (cs *CachedSqlizer) Invalidate() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.rendered = false
+	cs.statement, cs.args, cs.err = "", nil, nil
+}
@@ -1,6 +1,11 @@
 package sqrlx
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/elgris/sqrl"
+)
 
 func compareSQL(t testing.TB, stmt Sqlizer, wantText string, wantArgs ...interface{}) {
 
@@ -49,3 +54,374 @@ func TestUpsertComplex(t *testing.T) {
 		"WHERE updated > ?", 1234, "a", "ASDF", true, 55)
 
 }
+
+func TestCaseSum(t *testing.T) {
+
+	b := CaseSum("amount", "status = ? AND kind = ?", "paid", "sale")
+
+	compareSQL(t, b, "COALESCE(SUM(CASE WHEN status = ? AND kind = ? THEN COALESCE(amount,0) ELSE 0 END), 0)",
+		"paid", "sale")
+
+}
+
+func TestSumFilter(t *testing.T) {
+
+	b := SumFilter("amount", "status = ? AND kind = ?", "paid", "sale")
+
+	compareSQL(t, b, "COALESCE(SUM(COALESCE(amount,0)) FILTER (WHERE status = ? AND kind = ?), 0)",
+		"paid", "sale")
+
+}
+
+func TestUpsertConstraint(t *testing.T) {
+
+	b := Upsert("table").OnConstraint("idx_table_email").Set("email", "a@b.c").Set("data", "ASDF")
+
+	compareSQL(t, b, "INSERT INTO table (email,data) VALUES (?,?) "+
+		"ON CONFLICT ON CONSTRAINT idx_table_email DO UPDATE SET email = EXCLUDED.email, data = EXCLUDED.data",
+		"a@b.c", "ASDF")
+
+	if _, _, err := Upsert("table").OnConstraint("idx").Key("id", 1).Set("data", "ASDF").ToSql(); err == nil {
+		t.Errorf("Expected error for both keys and constraint")
+	}
+
+}
+
+func TestUpsertKeyExpr(t *testing.T) {
+
+	b := Upsert("users").
+		KeyExpr("email", "lower(email)", "A@b.c").
+		Set("name", "A")
+
+	compareSQL(t, b, "INSERT INTO users (email,name) VALUES (?,?) "+
+		"ON CONFLICT (lower(email)) DO UPDATE SET name = EXCLUDED.name",
+		"A@b.c", "A")
+
+}
+
+func TestUpsertWhere(t *testing.T) {
+
+	b := Upsert("table").
+		Key("id", 1234).
+		Set("data", "ASDF").
+		Where("updated < ?", 55).
+		Where(map[string]interface{}{"locked": false}).
+		Where(sqrl.Expr("version = ?", 3))
+
+	want := "INSERT INTO table (id,data) VALUES (?,?) " +
+		"ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data " +
+		"WHERE updated < ? AND locked = ? AND version = ?"
+
+	compareSQL(t, b, want, 1234, "ASDF", 55, false, 3)
+
+	// Rendering must not accumulate state in the builder
+	compareSQL(t, b, want, 1234, "ASDF", 55, false, 3)
+
+	if _, _, err := Upsert("table").Key("id", 1).Set("data", "ASDF").Where(5).ToSql(); err == nil {
+		t.Errorf("Expected error for unsupported predicate")
+	}
+
+}
+
+func TestUpsertSetExpr(t *testing.T) {
+
+	b := Upsert("counter").
+		Key("id", 1234).
+		Set("count", 1).
+		Set("data", "ASDF").
+		SetExpr("count", "counter.count + EXCLUDED.count").
+		SetExpr("updated", "GREATEST(counter.updated, ?)", 55).
+		Where("counter.locked = ?", false)
+
+	compareSQL(t, b, "INSERT INTO counter (id,count,data) VALUES (?,?,?) "+
+		"ON CONFLICT (id) DO UPDATE SET count = counter.count + EXCLUDED.count, data = EXCLUDED.data, updated = GREATEST(counter.updated, ?) "+
+		"WHERE counter.locked = ?",
+		1234, 1, "ASDF", 55, false)
+
+	if _, _, err := Upsert("counter").Key("id", 1).Set("count", 1).
+		SetExpr("count", "1").SetExpr("count", "2").ToSql(); err == nil {
+		t.Errorf("Expected error for duplicate update expression")
+	}
+
+	if _, _, err := Upsert("counter").Key("id", 1).Set("count", 1).
+		SetExpr("id", "2").ToSql(); err == nil {
+		t.Errorf("Expected error for update expression on a key column")
+	}
+
+}
+
+func TestUpsertDuplicateColumns(t *testing.T) {
+
+	_, _, err := Upsert("table").
+		Key("id", 1).
+		Set("data", "a").
+		Set("id", 2).
+		Set("data", "b").
+		ToSql()
+	if err == nil {
+		t.Fatal("Expected error for duplicate columns")
+	}
+	if err.Error() != "duplicate column in upsert: id is both a key and a value" {
+		t.Errorf("Expected the first duplicate to be reported, got %s", err)
+	}
+
+	_, _, err = Upsert("table").
+		Key("id", 1).
+		Set("data", "a").
+		Set("data", "b").
+		ToSql()
+	if err == nil || err.Error() != "duplicate column in upsert: data is added as a value twice" {
+		t.Errorf("Expected duplicate value error, got %v", err)
+	}
+
+}
+
+func TestRaw(t *testing.T) {
+
+	compareSQL(t, Raw("SELECT a FROM b WHERE c = ? AND d = ?", 1, "x"),
+		"SELECT a FROM b WHERE c = ? AND d = ?", 1, "x")
+
+}
+
+func TestInClause(t *testing.T) {
+
+	compareSQL(t, InClause("id", []int64{1, 2, 3}), "id IN (?,?,?)", int64(1), int64(2), int64(3))
+
+	compareSQL(t, InClause("id", []string{}), "1=0")
+
+	compareSQL(t, sqrl.And{sqrl.Eq{"status": "paid"}, InClause("id", []int64{1})},
+		"(status = ? AND id IN (?))", "paid", int64(1))
+
+	compareSQL(t, sqrl.Or{InClause("id", []int64(nil)), sqrl.Eq{"status": "paid"}},
+		"(1=0 OR status = ?)", "paid")
+
+}
+
+func TestCaseSumSq(t *testing.T) {
+
+	cond := sqrl.And{sqrl.Eq{"status": "paid"}, sqrl.Expr("created > ?", 10)}
+
+	b := CaseSumSq("amount * ?", cond)
+	b.TargetArgs = []interface{}{100}
+
+	compareSQL(t, b, "COALESCE(SUM(CASE WHEN (status = ? AND created > ?) THEN COALESCE(amount * ?,0) ELSE 0 END), 0)",
+		"paid", 10, 100)
+
+	b.Filtered = true
+
+	compareSQL(t, b, "COALESCE(SUM(COALESCE(amount * ?,0)) FILTER (WHERE (status = ? AND created > ?)), 0)",
+		100, "paid", 10)
+
+}
+
+type countingSqlizer struct {
+	Sqlizer
+	calls int
+}
+
+func (cs *countingSqlizer) ToSql() (string, []interface{}, error) {
+	cs.calls++
+	return cs.Sqlizer.ToSql()
+}
+
+func TestCachedSqlizer(t *testing.T) {
+
+	counter := &countingSqlizer{
+		Sqlizer: Upsert("table").Key("id", 1234).Set("data", "ASDF"),
+	}
+	cached := Cache(counter)
+
+	want := "INSERT INTO table (id,data) VALUES (?,?) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data"
+	compareSQL(t, cached, want, 1234, "ASDF")
+	compareSQL(t, cached, want, 1234, "ASDF")
+	if counter.calls != 1 {
+		t.Errorf("Expected 1 render, got %d", counter.calls)
+	}
+
+	cached.Invalidate()
+	compareSQL(t, cached, want, 1234, "ASDF")
+	if counter.calls != 2 {
+		t.Errorf("Expected 2 renders after Invalidate, got %d", counter.calls)
+	}
+
+}
+
+func BenchmarkCachedSqlizer(b *testing.B) {
+	builder := Upsert("table").
+		Key("id", 1234).
+		Key("subkey", "a").
+		Set("data", "ASDF").
+		Set("fieldb", true).
+		Where("updated > ?", 55)
+
+	b.Run("Uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := builder.ToSql(); err != nil {
+				b.Fatal(err.Error())
+			}
+		}
+	})
+
+	b.Run("Cached", func(b *testing.B) {
+		cached := Cache(builder)
+		for i := 0; i < b.N; i++ {
+			if _, _, err := cached.ToSql(); err != nil {
+				b.Fatal(err.Error())
+			}
+		}
+	})
+}
+
+func TestDollarFormat(t *testing.T) {
+
+	for _, tc := range []struct {
+		name   string
+		input  string
+		expect string
+		err    bool
+	}{{
+		name:   "question",
+		input:  "SELECT a FROM b WHERE c = ? AND d = ?",
+		expect: "SELECT a FROM b WHERE c = $1 AND d = $2",
+	}, {
+		name:   "dollar",
+		input:  "SELECT a FROM b WHERE c = $1 AND d = $2",
+		expect: "SELECT a FROM b WHERE c = $1 AND d = $2",
+	}, {
+		name:   "escaped question with dollar",
+		input:  "SELECT a ?? 'k' FROM b WHERE c = $1",
+		expect: "SELECT a ? 'k' FROM b WHERE c = $1",
+	}, {
+		name:   "dollar quoted string",
+		input:  "SELECT $$a$$ FROM b WHERE c = ?",
+		expect: "SELECT $$a$$ FROM b WHERE c = $1",
+	}, {
+		name:   "dollar in literal",
+		input:  "SELECT a FROM b WHERE price_label = '$5' AND id = ?",
+		expect: "SELECT a FROM b WHERE price_label = '$5' AND id = $1",
+	}, {
+		name:   "dollar in quoted identifier and comment",
+		input:  `SELECT "$1" FROM b -- $2` + "\n" + "WHERE c = ? /* $3 */",
+		expect: `SELECT "$1" FROM b -- $2` + "\n" + "WHERE c = $1 /* $3 */",
+	}, {
+		name:   "dollar in function body",
+		input:  "UPDATE fns SET body = $body$ SELECT $1 + 1 $body$ WHERE name = ?",
+		expect: "UPDATE fns SET body = $body$ SELECT $1 + 1 $body$ WHERE name = $1",
+	}, {
+		name:   "dollar placeholders with question in literal",
+		input:  "SELECT a FROM b WHERE c = $1 AND d = 'why?'",
+		expect: "SELECT a FROM b WHERE c = $1 AND d = 'why?'",
+	}, {
+		name:  "mixed",
+		input: "SELECT a FROM b WHERE c = $1 AND d = ?",
+		err:   true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Dollar.ReplacePlaceholders(tc.input)
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if got != tc.expect {
+				t.Errorf("Expected %s, got %s", tc.expect, got)
+			}
+		})
+	}
+
+}
+
+func TestInsertSelect(t *testing.T) {
+
+	b := InsertSelect("dst", []string{"id", "name", "source"}, sqrl.Select("id", "name").
+		Column("?", "copied").
+		From("src").
+		Where(sqrl.Eq{"tenant": "t1"}).
+		Where("created > ?", 10))
+
+	compareSQL(t, b, "INSERT INTO dst (id,name,source) SELECT id, name, ? FROM src WHERE tenant = ? AND created > ?",
+		"copied", "t1", 10)
+
+	stmt, args, err := NewPostgres(nil).RenderSQL(b)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if stmt != "INSERT INTO dst (id,name,source) SELECT id, name, $1 FROM src WHERE tenant = $2 AND created > $3" {
+		t.Errorf("Unexpected statement %s", stmt)
+	}
+	if len(args) != 3 {
+		t.Errorf("Expected 3 args, got %v", args)
+	}
+
+}
+
+func TestCTEChain(t *testing.T) {
+
+	b := NewCTEChain().
+		With("u", sqrl.Insert("users").
+			Columns("email").
+			Values("a@b.c").
+			Suffix("ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email RETURNING id")).
+		With("m", Raw("INSERT INTO memberships (user_id, group_id) SELECT id, ? FROM u RETURNING user_id", 7)).
+		Final(Raw("SELECT user_id FROM m WHERE user_id > ?", 0))
+
+	compareSQL(t, b, "WITH u AS (INSERT INTO users (email) VALUES (?) "+
+		"ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email RETURNING id), "+
+		"m AS (INSERT INTO memberships (user_id, group_id) SELECT id, ? FROM u RETURNING user_id) "+
+		"SELECT user_id FROM m WHERE user_id > ?",
+		"a@b.c", 7, 0)
+
+	stmt, _, err := NewPostgres(nil).RenderSQL(b)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(stmt, "VALUES ($1)") || !strings.Contains(stmt, "SELECT id, $2 FROM u") || !strings.HasSuffix(stmt, "user_id > $3") {
+		t.Errorf("Placeholders not numbered across fragments: %s", stmt)
+	}
+
+	if _, _, err := NewCTEChain().Final(Raw("SELECT 1")).ToSql(); err == nil {
+		t.Errorf("Expected error without fragments")
+	}
+	if _, _, err := NewCTEChain().With("a", Raw("SELECT 1")).ToSql(); err == nil {
+		t.Errorf("Expected error without a final statement")
+	}
+
+}
+
+func TestUpsertReturningInsertedSQL(t *testing.T) {
+
+	b := Upsert("table").Key("id", 1234).Set("data", "ASDF").ReturningInserted()
+
+	compareSQL(t, b, "INSERT INTO table (id,data) VALUES (?,?) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data RETURNING (xmax = 0) AS inserted",
+		1234, "ASDF")
+}
+
+func TestSubQuery(t *testing.T) {
+
+	inner := sqrl.Select("a_id", "SUM(amount) AS total").
+		From("payments").
+		Where("status = ?", "settled").
+		GroupBy("a_id")
+
+	outer := sqrl.Select("a.id", "sub.total").
+		From("a").
+		JoinClause(sqrl.Expr("JOIN ? ON sub.a_id = a.id", SubQuery(inner, "sub"))).
+		Where("a.active = ?", true).
+		PlaceholderFormat(sqrl.Dollar)
+
+	compareSQL(t, outer, "SELECT a.id, sub.total FROM a JOIN (SELECT a_id, SUM(amount) AS total FROM payments WHERE status = $1 GROUP BY a_id) AS sub ON sub.a_id = a.id WHERE a.active = $2",
+		"settled", true)
+
+	from := sqrl.Expr("SELECT sub.a_id FROM ? WHERE sub.total > ?", SubQuery(inner, "sub"), 10)
+	compareSQL(t, from, "SELECT sub.a_id FROM (SELECT a_id, SUM(amount) AS total FROM payments WHERE status = ? GROUP BY a_id) AS sub WHERE sub.total > ?",
+		"settled", 10)
+
+	numbered := sqrl.Select("id").From("b").Where("c = ?", 1).PlaceholderFormat(sqrl.Dollar)
+	if _, _, err := SubQuery(numbered, "sub").ToSql(); err == nil {
+		t.Errorf("Expected error for numbered placeholders")
+	}
+}
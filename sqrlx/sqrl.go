@@ -3,28 +3,18 @@ package sqrlx
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// QueryError is thrown by all exec and query commands to wrap the driver error.
-// It includes the statement causing the error
-type QueryError struct {
-	cause     error
-	Statement string
-}
-
-// Cause gives the driver error which was thrown
-func (err QueryError) Unwrap() error {
-	return err.cause
-}
-
-// Error is the cause error + the statement causing it
-func (err QueryError) Error() string {
-	return err.cause.Error() + " `" + err.Statement + "` "
-}
-
 // Connection is Queryer + Begin
 type Connection interface {
 	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
@@ -35,6 +25,7 @@ type Connection interface {
 // Transactor is implemented by Wrapper
 type Transactor interface {
 	Transact(context.Context, *TxOptions, Callback) error
+	Autocommit(context.Context, AutocommitCallback) error
 }
 
 // ColumnType is implemented by *sql.ColumnType
@@ -51,6 +42,7 @@ type ColumnType interface {
 type Commander interface {
 	ExecRaw(context.Context, string, ...interface{}) (sql.Result, error)
 	Exec(context.Context, Sqlizer) (sql.Result, error)
+	ExecReturning(context.Context, Sqlizer) (*Rows, error)
 
 	QueryRaw(context.Context, string, ...interface{}) (*Rows, error)
 	Query(context.Context, Sqlizer) (*Rows, error)
@@ -60,9 +52,13 @@ type Commander interface {
 
 	SelectRow(context.Context, Sqlizer) *Row
 	Select(context.Context, Sqlizer) (*Rows, error)
+	SelectWithRetries(context.Context, int, Sqlizer) (*Rows, error)
+	SelectRawWithRetries(context.Context, int, string, ...interface{}) (*Rows, error)
 	Insert(context.Context, Sqlizer) (sql.Result, error)
 	InsertRow(context.Context, Sqlizer) (bool, error)
+	InsertReturningID(context.Context, Sqlizer, string) (int64, error)
 	InsertStruct(context.Context, string, ...interface{}) (sql.Result, error)
+	UpsertReturningInserted(context.Context, *UpsertBuilder) (bool, error)
 	Update(context.Context, Sqlizer) (sql.Result, error)
 	Delete(context.Context, Sqlizer) (sql.Result, error)
 }
@@ -76,6 +72,24 @@ type Transaction interface {
 type TxExtras interface {
 	Reset(context.Context) error
 	PrepareRaw(context.Context, string) (*sql.Stmt, error)
+
+	// MarkRollback rolls the transaction back when the callback returns nil,
+	// in place of committing. Transact still returns nil, for callbacks which
+	// find there is nothing to do, e.g. an idempotent request which was
+	// already handled, and should discard anything written so far without
+	// the caller seeing an error or a retry.
+	MarkRollback()
+
+	// OnCommit registers fn to run after the transaction commits, e.g. to
+	// publish an event only once the data it describes is saved. OnRollback
+	// registers fn to run when Transact returns with the transaction rolled
+	// back, once, including when the attempts run out or the context is
+	// cancelled before a retry. Hooks of an attempt which is retried, or
+	// Reset, are discarded without running once the next attempt's callback
+	// runs, and neither runs when the outcome of a commit is unknown, see
+	// CommitAmbiguousError.
+	OnCommit(fn func())
+	OnRollback(fn func())
 }
 
 type PlaceholderFormat interface {
@@ -86,50 +100,287 @@ type Sqlizer interface {
 	ToSql() (string, []interface{}, error)
 }
 
+// Wrapper runs transactions with Transact, and also implements Commander to
+// run statements directly against the connection, outside of a transaction.
+//
+// The exported fields are configuration, to be set before the Wrapper is
+// used. Changing them while statements run is a data race, use
+// SetQueryLogger to change the logger at runtime.
 type Wrapper struct {
 	db                Connection
 	placeholderFormat PlaceholderFormat
 
+	*commandWrapper
+
 	// Max number of retries in acquiring transactions, or retrying due to
-	// transient or transaction conflict errors.
+	// transient or transaction conflict errors. Values less than 1 are treated
+	// as 1, i.e. the transaction is attempted exactly once.
 	RetryCount int
 
+	// Max number of attempts for a single Select, which is cheaper to re-run
+	// than a whole transaction. Zero uses RetryCount.
+	SelectRetryCount int
+
 	// Called when a transaction callback returns an error, if true, will retry
 	// the callback when ShouldRetryTransaction is also true.
-	// Note this does not effect errors on the Begin() and Commit() calls.
+	// Also decides whether a failed Commit() is retried, unless the outcome
+	// of the commit is unknown, see RetryAmbiguousCommits.
+	// Note this does not effect errors on the Begin() call.
 	ShouldRetryTransaction func(error) bool
 
+	// When true, commits which fail with a CommitAmbiguousError are retried,
+	// which is only safe when the transaction is idempotent, as the first
+	// attempt may have committed.
+	RetryAmbiguousCommits bool
+
+	// When true, transactions aborted by a Postgres deadlock (40P01) are
+	// retried even when TxOptions.Retryable is false. A deadlock rolls back
+	// the whole transaction, so no database writes from the attempt remain,
+	// but the callback runs again: anything it does outside the database,
+	// such as sending a message, is repeated. Off by default, so a callback
+	// is only ever re-run when its options are Retryable.
+	RetryDeadlocks bool
+
 	DefaultTxOptions *TxOptions
 
+	// When true, a panic in a transaction callback is recovered and returned
+	// as an error. When false the transaction is rolled back and the panic
+	// continues, preserving the original stack, which is useful in tests.
+	RecoverPanics bool
+
+	// Logs each statement. Set before use, SetQueryLogger replaces it safely
+	// while statements run.
 	QueryLogger QueryLogger
+
+	// Limits the duration of each statement, in addition to any deadline on
+	// the context passed in. Zero means no limit.
+	QueryTimeout time.Duration
+
+	// Called with each statement after placeholder replacement, the returned
+	// statement is sent to the driver instead. Use to normalize statements or
+	// to add comments for attribution. Nil leaves statements unchanged.
+	StatementRewriter func(ctx context.Context, statement string) string
+
+	// StrictReads runs each Select as read only, so statements which modify
+	// data fail rather than running again when the select is retried. Only
+	// applies to Postgres, it is ignored for other placeholder formats.
+	//
+	// Outside of a transaction each select runs in its own read only
+	// transaction. Within a transaction, the select runs inside a savepoint
+	// with transaction_read_only set, and the savepoint is rolled back when
+	// the rows are closed, which restores the read-write state. As the rows
+	// hold the connection, they must be closed before the next statement.
+	StrictReads bool
+
+	// When true, each statement sent to the driver, after placeholder
+	// replacement and rewriting, is recorded with its args, to be read with
+	// DrainStatements, e.g. to assert the statements a function ran in a
+	// test. Statements are kept until drained.
+	RecordStatements bool
+
+	// When true, slice params other than []byte and driver.Valuer types,
+	// such as pq.Array, are expanded to a placeholder per element, so
+	// `id IN (?)` can take a []int, see expandSliceParams. This applies to
+	// every method, raw or not, except Prepared statements. Leave unset for
+	// drivers which accept slices as a single param, e.g. pgx with
+	// `id = ANY(?)`.
+	ExpandSlices bool
+
+	// Max number of params in a single statement, including each element of
+	// an expanded slice, checked before the statement reaches the driver,
+	// which may fail opaquely. Zero means no limit. NewPostgres defaults to
+	// PostgresMaxParams.
+	MaxParams int
+
+	// When true, Close also closes the connection, which must implement
+	// io.Closer as *sql.DB does. Leave unset when the connection is shared.
+	OwnsConnection bool
+
+	// When true, statements run with Exec or ExecRaw directly on the Wrapper
+	// are logged and recorded but not sent to the driver, and return a
+	// result with 0 rows affected, e.g. to show what a migration would do.
+	// Writes which return rows, ExecReturning, InsertReturningID and
+	// UpsertReturningInserted, are logged and fail with ErrDryRun. Reads
+	// still run, as does anything run with Query, QueryRow or QueryRaw,
+	// including writes. Prepared statements from Prepare follow the same rule: Exec is
+	// skipped, and Query fails with ErrDryRun unless the statement starts
+	// with SELECT, VALUES, SHOW or TABLE. Only statements run on the pool
+	// are affected, a transaction from Transact runs every statement.
+	DryRun bool
+
+	recorder  *statementRecorder
+	lifecycle *lifecycle
+	logger    *loggerSwitch
+}
+
+// RecordedStatement is a statement recorded when RecordStatements is set
+type RecordedStatement struct {
+	Statement string
+	Args      []interface{}
+}
+
+// statementRecorder is shared by copies of the Wrapper, including those made
+// for each transaction
+type statementRecorder struct {
+	lock       sync.Mutex
+	statements []RecordedStatement
+}
+
+func (w Wrapper) recordStatement(statement string, args []interface{}) {
+	if !w.RecordStatements || w.recorder == nil {
+		return
+	}
+	w.recorder.lock.Lock()
+	defer w.recorder.lock.Unlock()
+	w.recorder.statements = append(w.recorder.statements, RecordedStatement{
+		Statement: statement,
+		Args:      args,
+	})
+}
+
+// DrainStatements returns the statements recorded since the last call, in the
+// order they ran, see RecordStatements.
+func (w Wrapper) DrainStatements() []RecordedStatement {
+	if w.recorder == nil {
+		return nil
+	}
+	w.recorder.lock.Lock()
+	defer w.recorder.lock.Unlock()
+	statements := w.recorder.statements
+	w.recorder.statements = nil
+	return statements
 }
 
+var _ Commander = &Wrapper{}
+
+// QueryLogger receives each statement as it is sent to the driver: after
+// placeholders are replaced and the StatementRewriter is applied, so a
+// statement from a builder logs as $1 for Postgres, the same as a raw
+// statement. This holds for every method, within a transaction or not.
 type QueryLogger interface {
 	LogQuery(context.Context, string, ...interface{})
 }
 
+// loggerSwitch holds the logger set with SetQueryLogger, shared by copies of
+// the Wrapper
+type loggerSwitch struct {
+	current atomic.Pointer[loggerHolder]
+}
+
+// loggerHolder allows a nil logger to be stored, to disable logging
+type loggerHolder struct {
+	logger QueryLogger
+}
+
+// SetQueryLogger replaces the QueryLogger, and is safe to call while
+// statements and transactions run, e.g. to toggle logging with a feature
+// flag. Statements already started keep the logger they started with. nil
+// disables logging. The Wrapper must be created with New or NewPostgres.
+func (w *Wrapper) SetQueryLogger(logger QueryLogger) {
+	w.logger.current.Store(&loggerHolder{logger: logger})
+}
+
+// currentQueryLogger returns the logger from SetQueryLogger, if called,
+// otherwise the QueryLogger field
+func (w Wrapper) currentQueryLogger() QueryLogger {
+	if w.logger != nil {
+		if holder := w.logger.current.Load(); holder != nil {
+			return holder.logger
+		}
+	}
+	return w.QueryLogger
+}
+
 type WrapperCommander struct {
 	*Wrapper
 	Commander
 }
 
-func defaultShouldRetry(err error) bool {
-	var sqlState = ""
+type queryLoggerKey struct{}
+
+// WithQueryLogger returns a context which logs queries to logger, taking
+// precedence over the QueryLogger configured on the Wrapper.
+func WithQueryLogger(ctx context.Context, logger QueryLogger) context.Context {
+	return context.WithValue(ctx, queryLoggerKey{}, logger)
+}
+
+// ResultLogger can be implemented by a QueryLogger to also log the outcome of
+// each statement after it runs. rowsAffected is -1 for queries, where the
+// number of rows is not known until they are read, and when the driver does
+// not report it.
+type ResultLogger interface {
+	LogResult(ctx context.Context, statement string, rowsAffected int64, duration time.Duration, err error)
+}
+
+// TypedQueryLogger can be implemented by a QueryLogger to also receive the
+// operation which ran the statement, one of OpExec, OpQuery or OpSelect. When
+// implemented, LogTypedQuery is called in place of LogQuery. BuilderName gives
+// the builder which produced the statement.
+type TypedQueryLogger interface {
+	LogTypedQuery(ctx context.Context, op string, statement string, params ...interface{})
+}
+
+// Operations passed to TypedQueryLogger
+const (
+	// OpExec is a statement run with ExecRaw, or a builder with Exec
+	OpExec = "exec"
 
-	// github.com/lib/pq
-	if getPGCodeErr, ok := err.(interface {
-		Get(byte) string
-	}); ok {
-		sqlState = getPGCodeErr.Get('C')
+	// OpQuery is a statement run once with QueryRaw or Query
+	OpQuery = "query"
+
+	// OpSelect is each attempt of SelectRaw or Select
+	OpSelect = "select"
+)
+
+type builderKey struct{}
+
+// withBuilder records bb as the source of statements run with ctx
+func withBuilder(ctx context.Context, bb Sqlizer) context.Context {
+	return context.WithValue(ctx, builderKey{}, bb)
+}
+
+// BuilderName returns the type of the Sqlizer which built the statement being
+// run, e.g. "sqrlx.UpsertBuilder" or "sqrl.SelectBuilder", for a QueryLogger
+// to group metrics by builder. Statements run by the raw methods, e.g.
+// ExecRaw, are "raw". The name is only looked up when called, so there is no
+// cost to loggers which don't use it.
+func BuilderName(ctx context.Context) string {
+	bb, ok := ctx.Value(builderKey{}).(Sqlizer)
+	if !ok || bb == nil {
+		return "raw"
 	}
+	rt := reflect.TypeOf(bb)
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	return rt.String()
+}
+
+// contextQueryLogger returns the context logger if set, otherwise fallback
+func contextQueryLogger(ctx context.Context, fallback QueryLogger) QueryLogger {
+	if ctxLogger, ok := ctx.Value(queryLoggerKey{}).(QueryLogger); ok && ctxLogger != nil {
+		return ctxLogger
+	}
+	return fallback
+}
 
-	// TODO: Other drivers. Really this should be part of the database/sql library.
+func logQuery(ctx context.Context, logger QueryLogger, op string, statement string, params ...interface{}) {
+	if logger == nil {
+		return
+	}
+	if typedLogger, ok := logger.(TypedQueryLogger); ok {
+		typedLogger.LogTypedQuery(ctx, op, statement, params...)
+		return
+	}
+	logger.LogQuery(ctx, statement, params...)
+}
 
-	if sqlState == "40001" {
-		// serilaization failure, in the SQL standard
-		return true
+func logResult(ctx context.Context, logger QueryLogger, statement string, rowsAffected int64, duration time.Duration, err error) {
+	resultLogger, ok := logger.(ResultLogger)
+	if !ok {
+		return
 	}
-	return false
+	resultLogger.LogResult(ctx, statement, rowsAffected, duration, err)
 }
 
 type CallbackLogger func(context.Context, string)
@@ -148,6 +399,45 @@ func (cb CallbackLogger) LogQuery(ctx context.Context, statement string, params
 	}
 }
 
+// FieldsLogger is a CallbackLogger which prefixes each line with values from
+// the context, e.g. a request or trace id, so that queries can be correlated
+// with the request that ran them.
+type FieldsLogger struct {
+	Callback CallbackLogger
+
+	// ContextFields are the context keys to look up, values are prefixed in
+	// this order, and keys not set on the context are skipped.
+	ContextFields []interface{}
+}
+
+// LoggerWithFields returns a FieldsLogger writing to cb, e.g.
+//
+//	wrapper.QueryLogger = sqrlx.LoggerWithFields(cb, requestIDKey{})
+func LoggerWithFields(cb CallbackLogger, contextFields ...interface{}) *FieldsLogger {
+	return &FieldsLogger{
+		Callback:      cb,
+		ContextFields: contextFields,
+	}
+}
+
+func (fl FieldsLogger) LogQuery(ctx context.Context, statement string, params ...interface{}) {
+	values := make([]string, 0, len(fl.ContextFields))
+	for _, key := range fl.ContextFields {
+		if val := ctx.Value(key); val != nil {
+			values = append(values, fmt.Sprint(val))
+		}
+	}
+	if len(values) == 0 {
+		fl.Callback.LogQuery(ctx, statement, params...)
+		return
+	}
+
+	prefix := "[" + strings.Join(values, " ") + "] "
+	CallbackLogger(func(ctx context.Context, line string) {
+		fl.Callback(ctx, prefix+line)
+	}).LogQuery(ctx, statement, params...)
+}
+
 func TestQueryLogger(t interface {
 	Log(...interface{})
 	Helper()
@@ -158,94 +448,255 @@ func TestQueryLogger(t interface {
 	})
 }
 
-func New(conn Connection, placeholder PlaceholderFormat) (*Wrapper, error) {
-	return &Wrapper{
+func newWrapper(conn Connection, placeholder PlaceholderFormat) *Wrapper {
+	ww := &Wrapper{
 		db:                     conn,
 		placeholderFormat:      placeholder,
 		RetryCount:             5,
 		ShouldRetryTransaction: defaultShouldRetry,
+		RecoverPanics:          true,
+		recorder:               &statementRecorder{},
+		lifecycle:              &lifecycle{},
+		logger:                 &loggerSwitch{},
 		DefaultTxOptions: &TxOptions{
 			ReadOnly:  false,
 			Isolation: sql.LevelSerializable,
 		},
-	}, nil
+	}
+	ww.commandWrapper = &commandWrapper{
+		rawCommander: rawDirect{db: conn, PlaceholderFormat: placeholder, connWrapper: ww},
+	}
+	return ww
 }
 
-func NewPostgres(conn Connection) *Wrapper {
-	return &Wrapper{
-		db:                     conn,
-		placeholderFormat:      Dollar,
-		RetryCount:             5,
-		ShouldRetryTransaction: defaultShouldRetry,
-		DefaultTxOptions: &TxOptions{
-			ReadOnly:  false,
-			Isolation: sql.LevelSerializable,
-		},
+func New(conn Connection, placeholder PlaceholderFormat, options ...WrapperOption) (*Wrapper, error) {
+	ww := newWrapper(conn, placeholder)
+	for _, option := range options {
+		option(ww)
 	}
+	return ww, nil
 }
 
-func NewWithCommander(conn Connection, placeholder PlaceholderFormat) (*WrapperCommander, error) {
-	ww := &Wrapper{
-		db:                     conn,
-		placeholderFormat:      placeholder,
-		RetryCount:             5,
-		ShouldRetryTransaction: defaultShouldRetry,
-		DefaultTxOptions: &TxOptions{
-			ReadOnly:  false,
-			Isolation: sql.LevelSerializable,
-		},
+// NewPostgres wraps a Postgres connection. Without options, transactions
+// default to LevelSerializable.
+func NewPostgres(conn Connection, options ...WrapperOption) *Wrapper {
+	ww := newWrapper(conn, Dollar)
+	ww.MaxParams = PostgresMaxParams
+	for _, option := range options {
+		option(ww)
 	}
-	commander := &commandWrapper{
-		rawCommander: rawDirect{db: conn, PlaceholderFormat: placeholder},
+	return ww
+}
+
+// WrapperOption configures a Wrapper at construction
+type WrapperOption func(*Wrapper)
+
+// WithDefaultTxOptions sets the options used when Transact is called with nil
+func WithDefaultTxOptions(opts *TxOptions) WrapperOption {
+	return func(ww *Wrapper) {
+		ww.DefaultTxOptions = opts
+	}
+}
+
+// WithDefaultIsolation sets the isolation level of the default options, used
+// when Transact is called with nil
+func WithDefaultIsolation(level sql.IsolationLevel) WrapperOption {
+	return func(ww *Wrapper) {
+		opts := TxOptions{}
+		if ww.DefaultTxOptions != nil {
+			opts = *ww.DefaultTxOptions
+		}
+		opts.Isolation = level
+		ww.DefaultTxOptions = &opts
+	}
+}
+
+// WithTransactionRetries sets the wrapper RetryCount
+func WithTransactionRetries(count int) WrapperOption {
+	return func(ww *Wrapper) {
+		ww.RetryCount = count
+	}
+}
+
+// WithMaxParams sets the wrapper MaxParams
+func WithMaxParams(maxParams int) WrapperOption {
+	return func(ww *Wrapper) {
+		ww.MaxParams = maxParams
+	}
+}
+
+// WithSliceExpansion sets the wrapper ExpandSlices
+func WithSliceExpansion() WrapperOption {
+	return func(ww *Wrapper) {
+		ww.ExpandSlices = true
+	}
+}
+
+// WithRetryClassifier sets ShouldRetryTransaction
+func WithRetryClassifier(shouldRetry func(error) bool) WrapperOption {
+	return func(ww *Wrapper) {
+		ww.ShouldRetryTransaction = shouldRetry
 	}
+}
 
+func NewWithCommander(conn Connection, placeholder PlaceholderFormat) (*WrapperCommander, error) {
+	ww := newWrapper(conn, placeholder)
 	return &WrapperCommander{
 		Wrapper:   ww,
-		Commander: commander,
+		Commander: ww.commandWrapper,
 	}, nil
 }
 
 type TxOptions struct {
+	// Isolation is checked to be a level defined by database/sql before the
+	// transaction begins. Drivers may still reject levels they do not
+	// support, e.g. lib/pq has no LevelLinearizable, which fails each
+	// Begin() and so uses up every retry.
 	Isolation sql.IsolationLevel
 	ReadOnly  bool
 
 	// Transaction callback will be called more than once to retry some errors.
-	// Errors which will result in retries are any error on the transaction
-	// Commit() call, or any errors returned from the callback for which
-	// `wrapper.ShouldRetryTransaction` returns true
+	// Errors from the callback or the Commit() call are retried only when
+	// both Retryable is set and `wrapper.ShouldRetryTransaction` returns true
+	// for the error. When false the callback runs at most once, unless
+	// `wrapper.RetryDeadlocks` is enabled.
 	//
 	// Errors from the Begin() call will always retry up to `wrapper.RetryCount`
 	Retryable bool
+
+	// Deferrable runs SET CONSTRAINTS ALL DEFERRED at the start of each
+	// transaction, so deferrable constraints are checked at commit. Only
+	// applies to Postgres, it is ignored for other placeholder formats.
+	Deferrable bool
+
+	// SearchPath sets the Postgres search_path for the duration of each
+	// transaction. It is ignored for other placeholder formats.
+	SearchPath string
+
+	// InitStatements are run in order at the start of each transaction, after
+	// Deferrable and SearchPath, including when the transaction is retried.
+	InitStatements []string
+
+	// RetryCount overrides the wrapper RetryCount for this transaction when
+	// greater than zero.
+	RetryCount int
+}
+
+// TxOption sets a field of TxOptions, for use with TransactOpts
+type TxOption func(*TxOptions)
+
+// WithReadOnly runs the transaction as read only
+func WithReadOnly() TxOption {
+	return func(opts *TxOptions) {
+		opts.ReadOnly = true
+	}
+}
+
+// WithIsolation sets the isolation level of the transaction
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(opts *TxOptions) {
+		opts.Isolation = level
+	}
+}
+
+// WithRetryable allows the callback to be called more than once, see
+// TxOptions.Retryable
+func WithRetryable() TxOption {
+	return func(opts *TxOptions) {
+		opts.Retryable = true
+	}
+}
+
+// WithRetryCount overrides the wrapper RetryCount for the transaction
+func WithRetryCount(count int) TxOption {
+	return func(opts *TxOptions) {
+		opts.RetryCount = count
+	}
+}
+
+// validate rejects options which could never begin a transaction
+func (opts TxOptions) validate() error {
+	if opts.Isolation < sql.LevelDefault || opts.Isolation > sql.LevelLinearizable {
+		return fmt.Errorf("invalid transaction isolation level %d", opts.Isolation)
+	}
+	return nil
 }
 
 type rawCommander interface {
 	QueryRaw(context.Context, string, ...interface{}) (*Rows, error)
 	ExecRaw(context.Context, string, ...interface{}) (sql.Result, error)
 	SelectRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error)
+	SelectRawWithRetries(ctx context.Context, retryCount int, statement string, params ...interface{}) (*Rows, error)
+	writeQueryRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error)
 	PlaceholderFormat
 }
 
 type Callback func(context.Context, Transaction) error
 
+// AutocommitCallback is called by Autocommit with a Commander which runs each
+// statement directly against the connection
+type AutocommitCallback func(context.Context, Commander) error
+
 // Transact calls cb within a transaction. The begin call is retried if
 // required. If cb returns an error, the transaction is rolled back, otherwise
-// it is committed. Failed commits are not retried, and will return an error
+// it is committed. Failed commits, and callback errors for which
+// ShouldRetryTransaction is true, are only retried when opts.Retryable is set,
+// otherwise they return an error. Every error is a *TransactError, including
+// ErrShuttingDown after Close and invalid options, which are PhaseBegin.
 func (w Wrapper) Transact(ctx context.Context, opts *TxOptions, cb Callback) (returnErr error) {
+	end, err := w.startTransaction()
+	if err != nil {
+		return &TransactError{Phase: PhaseBegin, Err: err}
+	}
+	defer end()
 
 	if opts == nil {
 		opts = w.DefaultTxOptions
 	}
 
-	var exitWithError error
+	if opts != nil {
+		// Copied so nothing below can modify the caller's options, or the
+		// DefaultTxOptions shared by every call
+		optsCopy := *opts
+		opts = &optsCopy
+		if err := opts.validate(); err != nil {
+			return &TransactError{Phase: PhaseBegin, Err: err}
+		}
+	}
+
+	var exitWithError *TransactError
+
+	// OnRollback hooks of the last attempt, which was rolled back to be
+	// retried, to run if Transact returns without another attempt
+	// reaching the end of its callback
+	var pendingRollback []func()
 
-	for tries := 0; tries < w.RetryCount; tries++ {
+	retryCount := w.RetryCount
+	if opts != nil && opts.RetryCount > 0 {
+		retryCount = opts.RetryCount
+	}
+	maxTries := attemptCount(retryCount)
+	retryable := opts != nil && opts.Retryable
+	for tries := 0; tries < maxTries; tries++ {
+		// A cancelled context would fail every remaining attempt. The error
+		// of the previous attempt, which was to be retried, is kept.
+		if err := ctx.Err(); err != nil {
+			runHooks(pendingRollback)
+			if exitWithError == nil {
+				return &TransactError{Phase: PhaseBegin, Err: err}
+			}
+			return &TransactError{
+				Phase: exitWithError.Phase,
+				Err:   errors.Join(err, exitWithError.Err),
+			}
+		}
 
 		txWrapped := &txWrapper{
 			opts:              opts,
 			connWrapper:       w,
 			PlaceholderFormat: w.placeholderFormat,
-			RetryCount:        w.RetryCount,
-			queryLogger:       w.QueryLogger,
+			SelectRetryCount:  w.selectRetryCount(),
+			queryLogger:       w.currentQueryLogger(),
 		}
 
 		commander := &commandWrapper{
@@ -253,45 +704,186 @@ func (w Wrapper) Transact(ctx context.Context, opts *TxOptions, cb Callback) (re
 		}
 
 		if err := txWrapped.begin(ctx); err != nil {
-			exitWithError = err
+			exitWithError = &TransactError{Phase: PhaseBegin, Err: err}
 			continue
 		}
 
 		if err := func() (err error) {
+			returned := false
 			defer func() {
+				if returned {
+					return
+				}
+				if !w.RecoverPanics {
+					// The panic continues with the original stack, but the
+					// transaction must still be rolled back
+					_ = txWrapped.tx.Rollback()
+					return
+				}
 				if r := recover(); r != nil {
 					err = fmt.Errorf("Panic: %s", r)
 					fmt.Println("Recovering TX Panic " + err.Error() + "\n" + string(debug.Stack()))
 				}
 			}()
-			return cb(ctx, Tx{
+			err = cb(ctx, Tx{
 				Commander: commander,
 				TxExtras:  txWrapped,
 			})
+			returned = true
+			return err
 		}(); err != nil {
-			if err := txWrapped.tx.Rollback(); err != nil {
+			// ErrTxDone means database/sql already rolled back, as it does
+			// when the context is cancelled
+			if err := txWrapped.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
 				// Retry will be a mess
-				return fmt.Errorf("rolling back transaction: %w", err)
+				return &TransactError{Phase: PhaseCallback, Err: fmt.Errorf("rolling back transaction: %w", err)}
 			}
 
-			if w.ShouldRetryTransaction != nil {
+			exitWithError = &TransactError{Phase: PhaseCallback, Err: err}
+			pendingRollback = txWrapped.onRollback
+			if retryable && w.ShouldRetryTransaction != nil {
 				if w.ShouldRetryTransaction(err) {
-					exitWithError = err
 					continue
 				}
 			}
-			return err
+			if w.RetryDeadlocks && isDeadlock(err) {
+				continue
+			}
+			runHooks(txWrapped.onRollback)
+			return exitWithError
+		}
+
+		if txWrapped.rollbackOnly {
+			if err := txWrapped.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+				return &TransactError{Phase: PhaseCommit, Err: fmt.Errorf("rolling back transaction: %w", err)}
+			}
+			runHooks(txWrapped.onRollback)
+			return nil
 		}
 
 		if err := txWrapped.tx.Commit(); err != nil {
-			exitWithError = fmt.Errorf("committing transaction: (%d/%d) %w", tries+1, w.RetryCount, err)
-			continue
+			retry := false
+			ambiguous := isCommitAmbiguous(err)
+			if ambiguous {
+				err = &CommitAmbiguousError{Err: err}
+				retry = w.RetryAmbiguousCommits
+			} else if w.ShouldRetryTransaction != nil {
+				retry = w.ShouldRetryTransaction(err)
+			}
+			exitWithError = &TransactError{
+				Phase: PhaseCommit,
+				Err:   fmt.Errorf("committing transaction: (%d/%d) %w", tries+1, maxTries, err),
+			}
+			// Hooks of an ambiguous commit never run, it may have committed
+			pendingRollback = nil
+			if !ambiguous {
+				pendingRollback = txWrapped.onRollback
+			}
+			if (retryable && retry) || (w.RetryDeadlocks && isDeadlock(err)) {
+				continue
+			}
+			runHooks(pendingRollback)
+			return exitWithError
 		}
+		runHooks(txWrapped.onCommit)
 		return nil
 	}
+	runHooks(pendingRollback)
 	return exitWithError
 }
 
+// Autocommit calls cb with a Commander outside of any transaction, for
+// statements which can't run in one, e.g. CREATE INDEX CONCURRENTLY or VACUUM.
+// Each statement commits as it runs, so there is no rollback if cb returns an
+// error, and cb is never retried. Statements may run on different connections
+// from the pool, so session state such as SET does not carry between them.
+func (w Wrapper) Autocommit(ctx context.Context, cb AutocommitCallback) error {
+	return cb(ctx, w.commandWrapper)
+}
+
+// TransactOpts is Transact with options applied over a copy of the wrapper
+// DefaultTxOptions, so only the fields which differ from the defaults need to
+// be set.
+func (w Wrapper) TransactOpts(ctx context.Context, cb Callback, options ...TxOption) error {
+	opts := &TxOptions{}
+	if w.DefaultTxOptions != nil {
+		*opts = *w.DefaultTxOptions
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	return w.Transact(ctx, opts, cb)
+}
+
+// Stats returns the connection pool statistics of the underlying connection.
+// The bool is false when the connection is not a *sql.DB.
+func (w Wrapper) Stats() (sql.DBStats, bool) {
+	db, ok := w.db.(*sql.DB)
+	if !ok {
+		return sql.DBStats{}, false
+	}
+	return db.Stats(), true
+}
+
+// PlaceholderFormat returns the format the wrapper was created with, for
+// code rendering SQL fragments outside of the wrapper. Formats set on the
+// context with WithPlaceholderFormat are not reflected.
+func (w Wrapper) PlaceholderFormat() PlaceholderFormat {
+	return w.placeholderFormat
+}
+
+func (w Wrapper) isPostgres() bool {
+	return isDollar(w.placeholderFormat)
+}
+
+func (w Wrapper) rewriteStatement(ctx context.Context, statement string) string {
+	if w.StatementRewriter != nil {
+		statement = w.StatementRewriter(ctx, statement)
+	}
+	if label, ok := ctx.Value(queryLabelKey{}).(string); ok && label != "" {
+		statement = statement + " /* " + escapeCommentText(label) + " */"
+	}
+	return statement
+}
+
+var commentReplacer = strings.NewReplacer("*/", "* /", "/*", "/ *")
+
+// escapeCommentText breaks up comment delimiters so text can't end the comment
+// early, or with "/*", open a nested one, as PostgreSQL block comments nest.
+// Replacing is repeated for overlapping delimiters, e.g. "/*/".
+func escapeCommentText(text string) string {
+	for strings.Contains(text, "*/") || strings.Contains(text, "/*") {
+		text = commentReplacer.Replace(text)
+	}
+	return text
+}
+
+type queryLabelKey struct{}
+
+// WithQueryLabel labels statements run with the context, appending the label
+// as a trailing comment, e.g. `SELECT ... /* ListUsers */`, so load can be
+// attributed in pg_stat_statements and logs. The comment is at the end so
+// that statements still share a common prefix.
+func WithQueryLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, queryLabelKey{}, label)
+}
+
+func (w Wrapper) selectRetryCount() int {
+	if w.SelectRetryCount == 0 {
+		return w.RetryCount
+	}
+	return w.SelectRetryCount
+}
+
+// attemptCount converts a configured retry count into the number of times an
+// operation should run, which is always at least once.
+func attemptCount(retryCount int) int {
+	if retryCount < 1 {
+		return 1
+	}
+	return retryCount
+}
+
 type Tx struct {
 	Commander
 	TxExtras
@@ -302,18 +894,70 @@ type txWrapper struct {
 	opts        *TxOptions
 	connWrapper Wrapper
 	PlaceholderFormat
-	RetryCount    int
-	isTransaction bool
-	queryLogger   QueryLogger
+	SelectRetryCount int
+	isTransaction    bool
+	queryLogger      QueryLogger
+
+	// rollbackOnly is set by MarkRollback
+	rollbackOnly bool
+
+	// prepared statements belong to tx, and are closed by Reset
+	prepared []*sql.Stmt
+
+	onCommit   []func()
+	onRollback []func()
+}
+
+// OnCommit registers fn to run after commit, see TxExtras
+func (w *txWrapper) OnCommit(fn func()) {
+	w.onCommit = append(w.onCommit, fn)
+}
+
+// OnRollback registers fn to run after the final rollback, see TxExtras
+func (w *txWrapper) OnRollback(fn func()) {
+	w.onRollback = append(w.onRollback, fn)
 }
 
+func runHooks(hooks []func()) {
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// MarkRollback rolls back the transaction in place of committing it, see
+// TxExtras
+func (w *txWrapper) MarkRollback() {
+	w.rollbackOnly = true
+}
+
+// Reset rolls back and begins a new transaction. Statements from PrepareRaw
+// are bound to the old transaction, so are closed.
 func (w *txWrapper) Reset(ctx context.Context) error {
+	w.onCommit = nil
+	w.onRollback = nil
+	if err := w.closePrepared(); err != nil {
+		return err
+	}
 	if err := w.tx.Rollback(); err != nil {
 		return err
 	}
 	return w.begin(ctx)
 }
 
+func (w *txWrapper) closePrepared() error {
+	var closeErrors []error
+	for _, stmt := range w.prepared {
+		if err := stmt.Close(); err != nil {
+			closeErrors = append(closeErrors, err)
+		}
+	}
+	w.prepared = nil
+	if err := errors.Join(closeErrors...); err != nil {
+		return fmt.Errorf("closing prepared statements: %w", err)
+	}
+	return nil
+}
+
 func (w *txWrapper) begin(ctx context.Context) error {
 	tx, err := w.connWrapper.db.BeginTx(ctx, &sql.TxOptions{
 		ReadOnly:  w.opts.ReadOnly,
@@ -323,26 +967,70 @@ func (w *txWrapper) begin(ctx context.Context) error {
 		return fmt.Errorf("beginning transaction: %w", err)
 	}
 	w.tx = tx
+	if err := w.initTx(ctx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
 	// rollback or commit happen after the callback returns in the initial Transact call
 	return nil
 }
 
-func (w txWrapper) PrepareRaw(ctx context.Context, str string) (*sql.Stmt, error) {
-	return w.tx.PrepareContext(ctx, str)
+// initTx runs statements required by the TxOptions at the start of each
+// transaction, including retries and resets.
+func (w *txWrapper) initTx(ctx context.Context) error {
+	if w.opts.Deferrable && w.connWrapper.isPostgres() {
+		if _, err := w.ExecRaw(ctx, "SET CONSTRAINTS ALL DEFERRED"); err != nil {
+			return fmt.Errorf("deferring constraints: %w", err)
+		}
+	}
+	if w.opts.SearchPath != "" && w.connWrapper.isPostgres() {
+		if _, err := w.ExecRaw(ctx, "SELECT set_config('search_path', $1, true)", w.opts.SearchPath); err != nil {
+			return fmt.Errorf("setting search_path: %w", err)
+		}
+	}
+	for _, statement := range w.opts.InitStatements {
+		if _, err := w.ExecRaw(ctx, statement); err != nil {
+			return fmt.Errorf("running transaction init statement: %w", err)
+		}
+	}
+	return nil
+}
+
+// PrepareRaw prepares a statement in the transaction. It is closed when the
+// transaction ends, or is Reset.
+func (w *txWrapper) PrepareRaw(ctx context.Context, str string) (*sql.Stmt, error) {
+	stmt, err := w.tx.PrepareContext(ctx, str)
+	if err != nil {
+		return nil, err
+	}
+	w.prepared = append(w.prepared, stmt)
+	return stmt, nil
 }
 
 // SelectRaw runs a string + params query, with automatic retry on transient
 // errors. Do not use SELECT queries to modify data.
 func (w txWrapper) SelectRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
+	return w.SelectRawWithRetries(ctx, w.SelectRetryCount, statement, params...)
+}
+
+// SelectRawWithRetries is SelectRaw, retrying up to retryCount times rather
+// than the wrapper SelectRetryCount. Transaction retries are unaffected.
+func (w txWrapper) SelectRawWithRetries(ctx context.Context, retryCount int, statement string, params ...interface{}) (*Rows, error) {
 	var err error
 	var rows *Rows
 	var firstError error
-	for tries := 0; tries < w.RetryCount; tries++ {
-		rows, err = w.QueryRaw(ctx, statement, params...)
-		if err == nil || err == sql.ErrNoRows || w.isTransaction {
+	maxTries := attemptCount(retryCount)
+	for tries := 0; tries < maxTries; tries++ {
+		rows, err = w.selectOnce(ctx, statement, params...)
+		if err == nil || errors.Is(err, sql.ErrNoRows) || w.isTransaction {
 			return rows, err
 		}
 
+		// The transaction is unusable after losing its connection
+		if isConnectionError(err) {
+			return nil, err
+		}
+
 		// TODO: Return immediately if it isn't a connection issue
 		if firstError == nil {
 			firstError = err
@@ -355,71 +1043,232 @@ func (w txWrapper) SelectRaw(ctx context.Context, statement string, params ...in
 	return rows, nil
 }
 
-// QueryRaw runs a query directly with the driver, returning wrapped rows. It
-// will not attempt to retry. No retries are attempted, Use SelectRaw for automatic retries
-func (w txWrapper) QueryRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
-	if w.queryLogger != nil {
-		w.queryLogger.LogQuery(ctx, statement, params...)
+const strictReadSavepoint = "sqrlx_strict_read"
+
+// selectOnce is a single attempt of SelectRaw, within a read only savepoint
+// when StrictReads is set
+func (w txWrapper) selectOnce(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
+	if !w.connWrapper.StrictReads || !w.connWrapper.isPostgres() {
+		return w.connWrapper.queryRaw(ctx, w.tx, w.queryLogger, OpSelect, statement, params...)
 	}
 
-	rows, err := w.tx.QueryContext(ctx, statement, params...) // nolint rowserrcheck
+	if _, err := w.ExecRaw(ctx, "SAVEPOINT "+strictReadSavepoint); err != nil {
+		return nil, fmt.Errorf("starting strict read: %w", err)
+	}
+	release := func() error {
+		if _, err := w.ExecRaw(ctx, "ROLLBACK TO SAVEPOINT "+strictReadSavepoint); err != nil {
+			return err
+		}
+		_, err := w.ExecRaw(ctx, "RELEASE SAVEPOINT "+strictReadSavepoint)
+		return err
+	}
+
+	if _, err := w.ExecRaw(ctx, "SET LOCAL transaction_read_only = on"); err != nil {
+		_ = release()
+		return nil, fmt.Errorf("starting strict read: %w", err)
+	}
+
+	rows, err := w.connWrapper.queryRaw(ctx, w.tx, w.queryLogger, OpSelect, statement, params...)
 	if err != nil {
+		// Also recovers the transaction from the failed statement
+		_ = release()
 		return nil, err
 	}
+	rows.release = release
+	return rows, nil
+}
 
-	return &Rows{
-		IRows: rows,
-	}, nil
+// QueryRaw runs a query directly with the driver, returning wrapped rows. It
+// will not attempt to retry. No retries are attempted, Use SelectRaw for automatic retries
+func (w txWrapper) QueryRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
+	return w.connWrapper.queryRaw(ctx, w.tx, w.queryLogger, OpQuery, statement, params...)
+}
+
+// writeQueryRaw is QueryRaw, DryRun does not apply in a transaction
+func (w txWrapper) writeQueryRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
+	return w.QueryRaw(ctx, statement, params...)
 }
 
 // ExecRaw runs an exec statement directly with the driver. No retries are attempted.
 func (w txWrapper) ExecRaw(ctx context.Context, statement string, params ...interface{}) (sql.Result, error) {
-	if w.queryLogger != nil {
-		w.queryLogger.LogQuery(ctx, statement, params...)
-	}
-
-	res, err := w.tx.ExecContext(ctx, statement, params...)
-	if err != nil {
-		return nil, &QueryError{
-			cause:     err,
-			Statement: statement,
-		}
-	}
-	return res, nil
+	return w.connWrapper.execRaw(ctx, w.tx, w.queryLogger, statement, params...)
 }
 
 type rawDirect struct {
 	db Connection
 	PlaceholderFormat
+	connWrapper *Wrapper
 }
 
-// SelectRaw runs a string + params query
+// SelectRaw runs a string + params query, retrying when the connection was
+// lost, as the pool provides a new connection for each attempt.
 func (w rawDirect) SelectRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
-	return w.QueryRaw(ctx, statement, params...)
+	return w.SelectRawWithRetries(ctx, w.connWrapper.selectRetryCount(), statement, params...)
+}
+
+// SelectRawWithRetries is SelectRaw, retrying up to retryCount times rather
+// than the wrapper SelectRetryCount.
+func (w rawDirect) SelectRawWithRetries(ctx context.Context, retryCount int, statement string, params ...interface{}) (*Rows, error) {
+	var firstError error
+	maxTries := attemptCount(retryCount)
+	for tries := 0; tries < maxTries; tries++ {
+		rows, err := w.selectOnce(ctx, statement, params...)
+		if err == nil || !isConnectionError(err) {
+			return rows, err
+		}
+		if firstError == nil {
+			firstError = err
+		}
+	}
+	return nil, firstError
+}
+
+// isConnectionError returns true when err was caused by the driver connection
+// being closed or broken
+func isConnectionError(err error) bool {
+	return errors.Is(err, sql.ErrConnDone) || errors.Is(err, driver.ErrBadConn)
+}
+
+// selectOnce is a single attempt of SelectRaw, within a read only transaction
+// when StrictReads is set
+func (w rawDirect) selectOnce(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
+	if !w.connWrapper.StrictReads || !w.connWrapper.isPostgres() {
+		return w.connWrapper.queryRaw(ctx, w.db, w.connWrapper.currentQueryLogger(), OpSelect, statement, params...)
+	}
+
+	tx, err := w.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("starting strict read: %w", err)
+	}
+
+	rows, err := w.connWrapper.queryRaw(ctx, tx, w.connWrapper.currentQueryLogger(), OpSelect, statement, params...)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	// Nothing was written, so there is nothing to commit
+	rows.release = tx.Rollback
+	return rows, nil
 }
 
 // QueryRaw runs a query directly with the driver, returning wrapped rows. It
 // will not attempt to retry. No retries are attempted, Use SelectRaw for automatic retries
 func (w rawDirect) QueryRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
-	rows, err := w.db.QueryContext(ctx, statement, params...) // nolint rowserrcheck
+	return w.connWrapper.queryRaw(ctx, w.db, w.connWrapper.currentQueryLogger(), OpQuery, statement, params...)
+}
+
+// ExecRaw runs an exec statement directly with the driver. No retries are attempted.
+func (w rawDirect) ExecRaw(ctx context.Context, statement string, params ...interface{}) (sql.Result, error) {
+	var conn queryExecer = w.db
+	if w.connWrapper.DryRun {
+		conn = dryRunExecer{}
+	}
+	return w.connWrapper.execRaw(ctx, conn, w.connWrapper.currentQueryLogger(), statement, params...)
+}
+
+// writeQueryRaw is QueryRaw for statements which write and return rows,
+// which fail with ErrDryRun when DryRun is set.
+func (w rawDirect) writeQueryRaw(ctx context.Context, statement string, params ...interface{}) (*Rows, error) {
+	var conn queryExecer = w.db
+	if w.connWrapper.DryRun {
+		conn = dryRunExecer{}
+	}
+	return w.connWrapper.queryRaw(ctx, conn, w.connWrapper.currentQueryLogger(), OpQuery, statement, params...)
+}
+
+// dryRunExecer skips each exec, for DryRun, and fails each query, as the rows
+// of a write can't be faked
+type dryRunExecer struct{}
+
+func (dryRunExecer) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, ErrDryRun
+}
+
+func (dryRunExecer) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+// queryExecer is implemented by both *sql.Tx and Connection
+type queryExecer interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}
+
+// queryRaw runs a query against either a transaction or the connection,
+// shared by the raw methods of txWrapper and rawDirect
+func (w Wrapper) queryRaw(ctx context.Context, conn queryExecer, logger QueryLogger, op string, statement string, params ...interface{}) (*Rows, error) {
+	if _, prepared := conn.(stmtExecer); !prepared {
+		var err error
+		statement, params, err = w.expandParams(statement, params)
+		if err != nil {
+			return nil, newQueryError(ctx, err, op, statement)
+		}
+	}
+	statement = w.rewriteStatement(ctx, statement)
+	if err := checkParamCount(params, w.MaxParams); err != nil {
+		return nil, newQueryError(ctx, err, op, statement)
+	}
+	logger = contextQueryLogger(ctx, logger)
+	logQuery(ctx, logger, op, statement, params...)
+	w.recordStatement(statement, params)
+
+	cancel := context.CancelFunc(func() {})
+	if w.QueryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, w.QueryTimeout)
+	}
+
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, statement, params...) // nolint rowserrcheck
+	logResult(ctx, logger, statement, -1, time.Since(start), err)
 	if err != nil {
-		return nil, err
+		// Before cancel, which would replace a deadline with Canceled
+		queryErr := newQueryError(ctx, err, op, statement)
+		cancel()
+		return nil, queryErr
 	}
 
+	// The context must remain valid until the rows are closed
 	return &Rows{
-		IRows: rows,
+		IRows:  rows,
+		cancel: cancel,
 	}, nil
 }
 
-// ExecRaw runs an exec statement directly with the driver. No retries are attempted.
-func (w rawDirect) ExecRaw(ctx context.Context, statement string, params ...interface{}) (sql.Result, error) {
-	res, err := w.db.ExecContext(ctx, statement, params...)
-	if err != nil {
-		return nil, &QueryError{
-			cause:     err,
-			Statement: statement,
+// execRaw runs an exec statement against either a transaction or the
+// connection, shared by the raw methods of txWrapper and rawDirect
+func (w Wrapper) execRaw(ctx context.Context, conn queryExecer, logger QueryLogger, statement string, params ...interface{}) (sql.Result, error) {
+	if _, prepared := conn.(stmtExecer); !prepared {
+		var err error
+		statement, params, err = w.expandParams(statement, params)
+		if err != nil {
+			return nil, newQueryError(ctx, err, OpExec, statement)
 		}
 	}
+	statement = w.rewriteStatement(ctx, statement)
+	if err := checkParamCount(params, w.MaxParams); err != nil {
+		return nil, newQueryError(ctx, err, OpExec, statement)
+	}
+	logger = contextQueryLogger(ctx, logger)
+	logQuery(ctx, logger, OpExec, statement, params...)
+	w.recordStatement(statement, params)
+
+	if w.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.QueryTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	res, err := conn.ExecContext(ctx, statement, params...)
+	if err != nil {
+		logResult(ctx, logger, statement, -1, time.Since(start), err)
+		return nil, newQueryError(ctx, err, OpExec, statement)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		rowsAffected = -1
+	}
+	logResult(ctx, logger, statement, rowsAffected, time.Since(start), nil)
 	return res, nil
 }
 
@@ -428,18 +1277,75 @@ type commandWrapper struct {
 	rawCommander
 }
 
-func (w commandWrapper) Exec(ctx context.Context, bb Sqlizer) (sql.Result, error) {
+type placeholderFormatKey struct{}
+
+// WithPlaceholderFormat returns a context which uses format in place of the
+// configured placeholder format for statements built from a Sqlizer.
+func WithPlaceholderFormat(ctx context.Context, format PlaceholderFormat) context.Context {
+	return context.WithValue(ctx, placeholderFormatKey{}, format)
+}
+
+// render builds the statement and replaces placeholders, using the context
+// placeholder format when set.
+func (w commandWrapper) render(ctx context.Context, bb Sqlizer) (string, []interface{}, error) {
 	statement, params, err := bb.ToSql()
 	if err != nil {
-		return nil, err
+		return "", nil, err
+	}
+	if strings.TrimSpace(statement) == "" {
+		return "", nil, &QueryError{
+			cause:     fmt.Errorf("%w from %T", ErrEmptyStatement, bb),
+			Statement: statement,
+		}
+	}
+	format := PlaceholderFormat(w.rawCommander)
+	if ctxFormat, ok := ctx.Value(placeholderFormatKey{}).(PlaceholderFormat); ok && ctxFormat != nil {
+		format = ctxFormat
 	}
-	statement, err = w.rawCommander.ReplacePlaceholders(statement)
+
+	statement, err = format.ReplacePlaceholders(statement)
+	if err != nil {
+		return "", nil, err
+	}
+	return statement, params, nil
+}
+
+// RenderSQL returns the statement and params Exec would send to the driver,
+// after placeholder replacement and slice expansion, without running it. The
+// StatementRewriter is not applied.
+func (w Wrapper) RenderSQL(bb Sqlizer) (string, []interface{}, error) {
+	statement, params, err := w.render(context.Background(), bb)
+	if err != nil {
+		return "", nil, err
+	}
+	return w.expandParams(statement, params)
+}
+
+func (w commandWrapper) Exec(ctx context.Context, bb Sqlizer) (sql.Result, error) {
+	ctx = withBuilder(ctx, bb)
+	statement, params, err := w.render(ctx, bb)
 	if err != nil {
 		return nil, err
 	}
 	return w.rawCommander.ExecRaw(ctx, statement, params...)
 }
 
+// ExecReturning runs a statement with a RETURNING clause once, without
+// retries, returning the rows it produced. Exec discards those rows, so use
+// this for INSERT, UPDATE or DELETE ... RETURNING. An error is returned when
+// the statement has no RETURNING clause.
+func (w commandWrapper) ExecReturning(ctx context.Context, bb Sqlizer) (*Rows, error) {
+	ctx = withBuilder(ctx, bb)
+	statement, params, err := w.render(ctx, bb)
+	if err != nil {
+		return nil, err
+	}
+	if !returningClause.MatchString(statement) {
+		return nil, fmt.Errorf("ExecReturning requires a RETURNING clause")
+	}
+	return w.rawCommander.writeQueryRaw(ctx, statement, params...)
+}
+
 // Deprecated: Use Exec
 func (w commandWrapper) Insert(ctx context.Context, bb Sqlizer) (sql.Result, error) {
 	return w.Exec(ctx, bb)
@@ -467,6 +1373,55 @@ func (w commandWrapper) InsertRow(ctx context.Context, bb Sqlizer) (bool, error)
 	return false, fmt.Errorf("%d rows effected by InsertRow", count)
 }
 
+var returningClause = regexp.MustCompile(`(?i)\bRETURNING\b`)
+
+// InsertReturningID runs an insert which must create exactly one row, and
+// returns the value of idColumn for the new row. RETURNING idColumn is
+// appended to the statement unless it already has a RETURNING clause.
+func (w commandWrapper) InsertReturningID(ctx context.Context, bb Sqlizer, idColumn string) (int64, error) {
+	ctx = withBuilder(ctx, bb)
+	statement, params, err := w.render(ctx, bb)
+	if err != nil {
+		return 0, err
+	}
+	if !returningClause.MatchString(statement) {
+		statement = statement + " RETURNING " + idColumn
+	}
+
+	var id int64
+	if err := rowFromRes(w.rawCommander.writeQueryRaw(ctx, statement, params...)).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("InsertReturningID returned no rows: %w", err)
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpsertReturningInserted runs the upsert with ReturningInserted, returning
+// true when a new row was inserted and false when an existing row was
+// updated. Postgres only. When a Where condition on the upsert skips the
+// update no row is returned, and the error wraps sql.ErrNoRows.
+func (w commandWrapper) UpsertReturningInserted(ctx context.Context, bb *UpsertBuilder) (bool, error) {
+	upsert := *bb
+	upsert.returningInserted = true
+
+	ctx = withBuilder(ctx, upsert)
+	statement, params, err := w.render(ctx, upsert)
+	if err != nil {
+		return false, err
+	}
+
+	var inserted bool
+	if err := rowFromRes(w.rawCommander.writeQueryRaw(ctx, statement, params...)).Scan(&inserted); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("UpsertReturningInserted returned no rows: %w", err)
+		}
+		return false, err
+	}
+	return inserted, nil
+}
+
 func (w commandWrapper) InsertStruct(ctx context.Context, tableName string, vals ...interface{}) (sql.Result, error) {
 	bb, err := InsertStruct(tableName, vals...)
 	if err != nil {
@@ -487,18 +1442,27 @@ func (w commandWrapper) Delete(ctx context.Context, bb Sqlizer) (sql.Result, err
 
 // Select runs a builder to query, returning Rows. Transient errors will be retried. Do not modify data in a select.
 func (w commandWrapper) Select(ctx context.Context, bb Sqlizer) (*Rows, error) {
-	statement, params, err := bb.ToSql()
+	ctx = withBuilder(ctx, bb)
+	statement, params, err := w.render(ctx, bb)
 	if err != nil {
 		return nil, err
 	}
 
-	statement, err = w.rawCommander.ReplacePlaceholders(statement)
+	return w.rawCommander.SelectRaw(ctx, statement, params...)
+
+}
+
+// SelectWithRetries is Select, retrying up to retryCount times rather than the
+// wrapper SelectRetryCount, for queries which warrant more retries, or should
+// fail fast. Transaction retries are unaffected.
+func (w commandWrapper) SelectWithRetries(ctx context.Context, retryCount int, bb Sqlizer) (*Rows, error) {
+	ctx = withBuilder(ctx, bb)
+	statement, params, err := w.render(ctx, bb)
 	if err != nil {
 		return nil, err
 	}
 
-	return w.rawCommander.SelectRaw(ctx, statement, params...)
-
+	return w.rawCommander.SelectRawWithRetries(ctx, retryCount, statement, params...)
 }
 
 // SelectRow returns a single row, otherwise is the same as Select
@@ -509,12 +1473,8 @@ func (w commandWrapper) SelectRow(ctx context.Context, bb Sqlizer) *Row {
 // Query runs the statement once, returning any error, it does not retry and so
 // is safe to use for UPDATE RETURNING
 func (w commandWrapper) Query(ctx context.Context, bb Sqlizer) (*Rows, error) {
-	statement, params, err := bb.ToSql()
-	if err != nil {
-		return nil, err
-	}
-
-	statement, err = w.rawCommander.ReplacePlaceholders(statement)
+	ctx = withBuilder(ctx, bb)
+	statement, params, err := w.render(ctx, bb)
 	if err != nil {
 		return nil, err
 	}
@@ -524,6 +1484,8 @@ func (w commandWrapper) Query(ctx context.Context, bb Sqlizer) (*Rows, error) {
 }
 
 // QueryRow returns a single row, otherwise is the same as Query. No retries are attempted.
+// With Row.ScanStruct, this is the way to read the row of an INSERT or UPDATE
+// ... RETURNING into a struct, as the statement is never run twice.
 func (w commandWrapper) QueryRow(ctx context.Context, bb Sqlizer) *Row {
 	return rowFromRes(w.Query(ctx, bb))
 }
@@ -2,14 +2,22 @@ package sqrlx
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	_ "github.com/lib/pq"
+	"github.com/elgris/sqrl"
+	"github.com/lib/pq"
 )
 
 type testPlaceholder struct{}
@@ -53,7 +61,7 @@ func testTransaction(t *testing.T, retryCount int) (Transaction, sqlmock.Sqlmock
 		//opts: opts,
 		//connWrapper:       w,
 		PlaceholderFormat: testPlaceholder{},
-		RetryCount:        retryCount,
+		SelectRetryCount:  retryCount,
 	}
 
 	commander := &commandWrapper{
@@ -180,7 +188,8 @@ func TestSelectRetry(t *testing.T) {
 
 func TestQueryRowServerError(t *testing.T) {
 	mockRows := &MockRows{
-		NextVal: true,
+		ColumnsVal: []string{"str"},
+		NextVal:    true,
 		ScanImpl: func(vals ...interface{}) error {
 			if len(vals) != 1 {
 				t.Fatalf("Should have 1 vals, got %v", vals)
@@ -207,6 +216,36 @@ func TestQueryRowServerError(t *testing.T) {
 
 }
 
+func TestRowExists(t *testing.T) {
+
+	for _, next := range []bool{true, false} {
+		mockRows := &MockRows{
+			NextVal: next,
+		}
+		exists, err := Row{Rows: mockRows}.Exists()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if exists != next {
+			t.Errorf("Expected %v, got %v", next, exists)
+		}
+		if !mockRows.DidClose {
+			t.Errorf("Rows did not get closed")
+		}
+	}
+
+	mockRows := &MockRows{
+		ErrVal: testError("server error"),
+	}
+	if _, err := (Row{Rows: mockRows}).Exists(); err == nil {
+		t.Errorf("Expected the rows error")
+	}
+
+	if _, err := rowFromRes(nil, testError("query error")).Exists(); err == nil {
+		t.Errorf("Expected the query error")
+	}
+}
+
 type MockResult struct {
 	lastInsertId int64
 	rowsAffected int64
@@ -356,3 +395,2191 @@ func TestTxPanic(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestSelectWithRetries(t *testing.T) {
+	ctx := context.Background()
+	q := testSqlizer{str: "SELECT a FROM b"}
+
+	t.Run("More", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery("SELECT a FROM b").WillReturnError(testError("1"))
+		mock.ExpectQuery("SELECT a FROM b").WillReturnError(testError("2"))
+		mock.ExpectQuery("SELECT a FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+
+		if _, err := tx.SelectWithRetries(ctx, 3, q); err != nil {
+			t.Fatalf("Got error %s", err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	t.Run("Fewer", func(t *testing.T) {
+		tx, mock := testTransaction(t, 4)
+
+		err1 := testError("1")
+		mock.ExpectQuery("SELECT a FROM b").WillReturnError(err1)
+		// Only reached with the default retry count
+		mock.ExpectQuery("SELECT a FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+
+		if _, err := tx.SelectRawWithRetries(ctx, 1, "SELECT a FROM b"); !errors.Is(err, err1) {
+			t.Fatalf("Expected first error, got %v", err)
+		}
+	})
+}
+
+func TestTxZeroRetryCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.RetryCount = 0
+
+	ctx := context.Background()
+
+	callbackErr := testError("callback")
+	calls := 0
+	err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+		calls++
+		return callbackErr
+	})
+	if !errors.Is(err, callbackErr) {
+		t.Errorf("Expected callback error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected callback to run once, ran %d times", calls)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTxSelectRetryCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT a FROM b").WillReturnError(testError("1"))
+	mock.ExpectQuery("SELECT a FROM b").WillReturnError(testError("2"))
+	mock.ExpectQuery("SELECT a FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+	mock.ExpectCommit()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.RetryCount = 1
+	w.SelectRetryCount = 3
+
+	ctx := context.Background()
+
+	err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+		rows, err := tx.Select(ctx, testSqlizer{str: "SELECT a FROM b"})
+		if err != nil {
+			return err
+		}
+		return rows.Close()
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestStatementRewriter(t *testing.T) {
+	rewriter := func(ctx context.Context, statement string) string {
+		return statement + " /* app:test */"
+	}
+
+	t.Run("Transaction", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO b VALUES (!) /* app:test */")).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w, err := New(db, testPlaceholder{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w.StatementRewriter = rewriter
+
+		ctx := context.Background()
+		err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+			_, err := tx.Exec(ctx, testSqlizer{str: "INSERT INTO b VALUES (?)", args: []interface{}{"c"}})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("Direct", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO b VALUES (!) /* app:test */")).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		w, err := NewWithCommander(db, testPlaceholder{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w.StatementRewriter = rewriter
+
+		ctx := context.Background()
+		if _, err := w.Exec(ctx, testSqlizer{str: "INSERT INTO b VALUES (?)", args: []interface{}{"c"}}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+}
+
+func TestWrapperDirect(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectQuery("SELECT a FROM b WHERE c = !").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO b VALUES (!)")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx := context.Background()
+
+	var a string
+	if err := w.SelectRow(ctx, testSqlizer{
+		str:  "SELECT a FROM b WHERE c = ?",
+		args: []interface{}{"hello"},
+	}).Scan(&a); err != nil {
+		t.Fatal(err.Error())
+	}
+	if a != "A" {
+		t.Errorf("Expected A, got %s", a)
+	}
+
+	if _, err := w.Exec(ctx, testSqlizer{
+		str:  "INSERT INTO b VALUES (?)",
+		args: []interface{}{"c"},
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestContextQueryLogger(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO b VALUES (!)")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO b VALUES (!)")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	wrapperLogs := []string{}
+	w.QueryLogger = CallbackLogger(func(ctx context.Context, line string) {
+		wrapperLogs = append(wrapperLogs, line)
+	})
+
+	contextLogs := []string{}
+	ctx := WithQueryLogger(context.Background(), CallbackLogger(func(ctx context.Context, line string) {
+		contextLogs = append(contextLogs, line)
+	}))
+
+	q := testSqlizer{
+		str:  "INSERT INTO b VALUES (?)",
+		args: []interface{}{"c"},
+	}
+
+	err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+		_, err := tx.Exec(ctx, q)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := w.Exec(ctx, q); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(wrapperLogs) != 0 {
+		t.Errorf("Expected no wrapper logs, got %v", wrapperLogs)
+	}
+	if len(contextLogs) != 4 {
+		t.Errorf("Expected statement and param for both calls, got %v", contextLogs)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestInsertReturningID(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Appends Returning", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO b (c) VALUES (!) RETURNING id")).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(55))
+
+		id, err := tx.InsertReturningID(ctx, testSqlizer{
+			str:  "INSERT INTO b (c) VALUES (?)",
+			args: []interface{}{"c"},
+		}, "id")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if id != 55 {
+			t.Errorf("Expected 55, got %d", id)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	t.Run("Existing Returning", func(t *testing.T) {
+		tx, mock := testTransaction(t, 1)
+
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO b (c) VALUES (!) ON CONFLICT DO NOTHING RETURNING b_id")).
+			WillReturnRows(sqlmock.NewRows([]string{"b_id"}))
+
+		_, err := tx.InsertReturningID(ctx, testSqlizer{
+			str:  "INSERT INTO b (c) VALUES (?) ON CONFLICT DO NOTHING RETURNING b_id",
+			args: []interface{}{"c"},
+		}, "b_id")
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("Expected ErrNoRows, got %v", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+}
+
+func TestUpsertReturningInserted(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO b (id,c) VALUES (!,!) ON CONFLICT (id) DO UPDATE SET c = EXCLUDED.c RETURNING (xmax = 0) AS inserted")).
+		WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(false))
+
+	bb := Upsert("b").Key("id", 1).Set("c", "c")
+	inserted, err := tx.UpsertReturningInserted(ctx, bb)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if inserted {
+		t.Error("Expected an update")
+	}
+
+	if bb.returningInserted {
+		t.Error("Expected the builder to be unchanged")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestTxPanicNoRecover(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.RecoverPanics = false
+
+	ctx := context.Background()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "Test Panic" {
+				t.Errorf("Expected the panic to propagate, got %v", r)
+			}
+		}()
+		_ = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+			panic("Test Panic")
+		})
+	}()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTxMarkRollback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx := context.Background()
+
+	calls := 0
+	if err := w.Transact(ctx, &TxOptions{Retryable: true}, func(ctx context.Context, tx Transaction) error {
+		calls++
+		if _, err := tx.ExecRaw(ctx, "UPDATE b"); err != nil {
+			return err
+		}
+		tx.MarkRollback()
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected callback to run once, ran %d times", calls)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTransactErrorPhase(t *testing.T) {
+	ctx := context.Background()
+	failure := testError("failure")
+
+	for _, tc := range []struct {
+		phase  TransactPhase
+		expect func(sqlmock.Sqlmock)
+	}{{
+		phase: PhaseBegin,
+		expect: func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin().WillReturnError(failure)
+			mock.ExpectBegin().WillReturnError(failure)
+		},
+	}, {
+		phase: PhaseCallback,
+		expect: func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE b").WillReturnError(failure)
+			mock.ExpectRollback()
+		},
+	}, {
+		phase: PhaseCommit,
+		expect: func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit().WillReturnError(failure)
+		},
+	}} {
+		t.Run(tc.phase.String(), func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			tc.expect(mock)
+
+			w, err := New(db, testPlaceholder{}, WithTransactionRetries(2))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+				_, err := tx.ExecRaw(ctx, "UPDATE b")
+				return err
+			})
+			txErr := &TransactError{}
+			if !errors.As(err, &txErr) {
+				t.Fatalf("Expected TransactError, got %v", err)
+			}
+			if txErr.Phase != tc.phase {
+				t.Errorf("Expected phase %s, got %s", tc.phase, txErr.Phase)
+			}
+			if !errors.Is(err, failure) {
+				t.Errorf("Expected the failure to be wrapped, got %v", err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err.Error())
+			}
+		})
+	}
+}
+
+func TestTxResetClosesPrepared(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT a FROM b").WillBeClosed()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx := context.Background()
+
+	if err := w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+		stmt, err := tx.PrepareRaw(ctx, "SELECT a FROM b")
+		if err != nil {
+			return err
+		}
+		if err := tx.Reset(ctx); err != nil {
+			return err
+		}
+
+		if prepared := tx.(Tx).TxExtras.(*txWrapper).prepared; len(prepared) != 0 {
+			t.Errorf("Expected no prepared statements after Reset, got %d", len(prepared))
+		}
+		if _, err := stmt.QueryContext(ctx); err == nil {
+			t.Errorf("Expected the old statement to be closed")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTxHooks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Commit After Retry", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		w, err := New(db, testPlaceholder{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w.ShouldRetryTransaction = func(error) bool { return true }
+
+		events := []string{}
+		attempt := 0
+		if err := w.Transact(ctx, &TxOptions{Retryable: true}, func(ctx context.Context, tx Transaction) error {
+			attempt++
+			name := fmt.Sprintf("attempt %d", attempt)
+			tx.OnCommit(func() { events = append(events, "commit "+name) })
+			tx.OnRollback(func() { events = append(events, "rollback "+name) })
+			if attempt == 1 {
+				return testError("retry")
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if strings.Join(events, ",") != "commit attempt 2" {
+			t.Errorf("Expected only the final commit hook, got %v", events)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("Retries Exhausted", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		w := NewPostgres(db)
+
+		rollbacks := 0
+		serialization := &pq.Error{Code: "40001"}
+		err = w.Transact(ctx, &TxOptions{
+			Isolation:  sql.LevelSerializable,
+			Retryable:  true,
+			RetryCount: 2,
+		}, func(ctx context.Context, tx Transaction) error {
+			tx.OnRollback(func() { rollbacks++ })
+			return serialization
+		})
+		if !errors.Is(err, serialization) {
+			t.Fatalf("Expected serialization failure, got %v", err)
+		}
+		if rollbacks != 1 {
+			t.Errorf("Expected the rollback hook to run once, ran %d times", rollbacks)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("Cancelled Before Retry", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		w := NewPostgres(db)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		rollbacks := 0
+		err = w.Transact(ctx, &TxOptions{
+			Isolation: sql.LevelSerializable,
+			Retryable: true,
+		}, func(ctx context.Context, tx Transaction) error {
+			tx.OnRollback(func() { rollbacks++ })
+			cancel()
+			return &pq.Error{Code: "40001"}
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context error, got %v", err)
+		}
+		if rollbacks != 1 {
+			t.Errorf("Expected the rollback hook to run once, ran %d times", rollbacks)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		w, err := New(db, testPlaceholder{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		events := []string{}
+		failure := testError("failure")
+		if err := w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+			tx.OnCommit(func() { events = append(events, "commit") })
+			tx.OnRollback(func() { events = append(events, "error") })
+			return failure
+		}); !errors.Is(err, failure) {
+			t.Fatalf("Expected failure, got %v", err)
+		}
+
+		if err := w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+			tx.OnCommit(func() { events = append(events, "commit") })
+			tx.OnRollback(func() { events = append(events, "marked") })
+			tx.MarkRollback()
+			return nil
+		}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if strings.Join(events, ",") != "error,marked" {
+			t.Errorf("Expected rollback hooks, got %v", events)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+}
+
+func TestTxDeferrable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SET CONSTRAINTS ALL DEFERRED").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("SET CONSTRAINTS ALL DEFERRED").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	w := NewPostgres(db)
+	w.ShouldRetryTransaction = func(err error) bool {
+		return true
+	}
+
+	ctx := context.Background()
+
+	calls := 0
+	err = w.Transact(ctx, &TxOptions{
+		Isolation:  sql.LevelSerializable,
+		Retryable:  true,
+		Deferrable: true,
+	}, func(ctx context.Context, tx Transaction) error {
+		calls++
+		if calls == 1 {
+			return testError("retry")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTxDeferrableNotPostgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx := context.Background()
+
+	err = w.Transact(ctx, &TxOptions{
+		Deferrable: true,
+	}, func(ctx context.Context, tx Transaction) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTxInitStatements(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT set_config('search_path', $1, true)")).
+		WithArgs("tenant_a, public").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET LOCAL lock_timeout = '1s'").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET LOCAL statement_timeout = '5s'").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	w := NewPostgres(db)
+
+	ctx := context.Background()
+
+	err = w.Transact(ctx, &TxOptions{
+		Isolation:  sql.LevelSerializable,
+		SearchPath: "tenant_a, public",
+		InitStatements: []string{
+			"SET LOCAL lock_timeout = '1s'",
+			"SET LOCAL statement_timeout = '5s'",
+		},
+	}, func(ctx context.Context, tx Transaction) error {
+		_, err := tx.ExecRaw(ctx, "DELETE FROM b")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestRowsCloseIdempotent(t *testing.T) {
+	mockRows := &MockRows{}
+	rows := &Rows{
+		IRows: mockRows,
+	}
+
+	if err := rows.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("Second close should not reach the underlying rows: %s", err)
+	}
+
+	row := rowFromRes(&Rows{
+		IRows: &MockRows{
+			NextVal: true,
+			ScanImpl: func(vals ...interface{}) error {
+				return nil
+			},
+		},
+	}, nil)
+	if err := row.Scan(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+type testResultLogger struct {
+	statements   []string
+	rowsAffected []int64
+	errs         []error
+}
+
+func (rl *testResultLogger) LogQuery(ctx context.Context, statement string, params ...interface{}) {}
+
+func (rl *testResultLogger) LogResult(ctx context.Context, statement string, rowsAffected int64, duration time.Duration, err error) {
+	rl.statements = append(rl.statements, statement)
+	rl.rowsAffected = append(rl.rowsAffected, rowsAffected)
+	rl.errs = append(rl.errs, err)
+}
+
+func TestResultLogger(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	throwErr := testError("ERR")
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE b SET c = !")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery("SELECT a FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+	mock.ExpectExec("DELETE FROM b").WillReturnError(throwErr)
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	logger := &testResultLogger{}
+	w.QueryLogger = logger
+
+	ctx := context.Background()
+
+	if _, err := w.Exec(ctx, testSqlizer{str: "UPDATE b SET c = ?", args: []interface{}{1}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	rows, err := w.Query(ctx, testSqlizer{str: "SELECT a FROM b"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+	if _, err := w.ExecRaw(ctx, "DELETE FROM b"); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	if len(logger.statements) != 3 {
+		t.Fatalf("Expected 3 results, got %v", logger.statements)
+	}
+	if logger.rowsAffected[0] != 3 || logger.errs[0] != nil {
+		t.Errorf("Exec: expected 3 rows affected, got %d %v", logger.rowsAffected[0], logger.errs[0])
+	}
+	if logger.rowsAffected[1] != -1 || logger.errs[1] != nil {
+		t.Errorf("Query: expected -1 rows, got %d %v", logger.rowsAffected[1], logger.errs[1])
+	}
+	if !errors.Is(logger.errs[2], throwErr) {
+		t.Errorf("Expected error result, got %v", logger.errs[2])
+	}
+}
+
+func TestWrapperPlaceholderFormat(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	w, err := New(db, Dollar)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.PlaceholderFormat() != Dollar {
+		t.Errorf("Expected Dollar, got %T", w.PlaceholderFormat())
+	}
+}
+
+func TestContextPlaceholderFormat(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO b VALUES (!)")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO b VALUES (?)")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	q := testSqlizer{
+		str:  "INSERT INTO b VALUES (?)",
+		args: []interface{}{"c"},
+	}
+
+	if _, err := tx.Exec(ctx, q); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := tx.Exec(WithPlaceholderFormat(ctx, Question), q); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestRenderSQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	w := NewPostgres(db)
+
+	statement, params, err := w.RenderSQL(testSqlizer{
+		str:  "SELECT a FROM b WHERE c = ? AND d = ?",
+		args: []interface{}{"c", "d"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if statement != "SELECT a FROM b WHERE c = $1 AND d = $2" {
+		t.Errorf("Unexpected statement %s", statement)
+	}
+	if len(params) != 2 {
+		t.Errorf("Unexpected params %v", params)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestSelectConnectionErrors(t *testing.T) {
+	ctx := context.Background()
+
+	q := testSqlizer{
+		str:  "SELECT a FROM b WHERE c = ?",
+		args: []interface{}{"hello"},
+	}
+
+	t.Run("Transaction", func(t *testing.T) {
+		tx, mock := testTransaction(t, 3)
+
+		mock.ExpectQuery("SELECT a FROM b WHERE c = !").
+			WillReturnError(sql.ErrConnDone)
+
+		_, err := tx.Select(ctx, q)
+		if !errors.Is(err, sql.ErrConnDone) {
+			t.Fatalf("Expected ErrConnDone, got %v", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	t.Run("Direct", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectQuery("SELECT a FROM b WHERE c = !").
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectQuery("SELECT a FROM b WHERE c = !").
+			WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+
+		w, err := New(db, testPlaceholder{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		rows, err := w.Select(ctx, q)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		rows.Close()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	t.Run("Direct Other Error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		throwErr := testError("ERR")
+		mock.ExpectQuery("SELECT a FROM b WHERE c = !").
+			WillReturnError(throwErr)
+
+		w, err := New(db, testPlaceholder{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := w.Select(ctx, q); !errors.Is(err, throwErr) {
+			t.Fatalf("Expected error, got %v", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectExec("UPDATE b").
+		WillDelayFor(time.Second).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT a FROM b").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A").AddRow("B"))
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.QueryTimeout = 10 * time.Millisecond
+
+	ctx := context.Background()
+
+	start := time.Now()
+	if _, err := w.ExecRaw(ctx, "UPDATE b"); err == nil {
+		t.Errorf("Expected timeout error")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Exec was not cancelled by QueryTimeout")
+	}
+
+	// Rows remain readable after the query returns, the context is only
+	// cancelled on close
+	rows, err := w.QueryRaw(ctx, "SELECT a FROM b")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 rows, got %d", count)
+	}
+}
+
+func TestQueryContextErrors(t *testing.T) {
+	// sqlmock, like many drivers, returns its own error when the context is
+	// done rather than the context error
+	for _, tc := range []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		expect error
+	}{{
+		name: "Canceled",
+		ctx: func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			return ctx, cancel
+		},
+		expect: context.Canceled,
+	}, {
+		name: "DeadlineExceeded",
+		ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 10*time.Millisecond)
+		},
+		expect: context.DeadlineExceeded,
+	}} {
+		t.Run(tc.name+" Tx", func(t *testing.T) {
+			tx, mock := testTransaction(t, 1)
+			mock.ExpectExec("UPDATE b").
+				WillDelayFor(time.Second).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			_, err := tx.ExecRaw(ctx, "UPDATE b")
+			queryErr := &QueryError{}
+			if !errors.As(err, &queryErr) {
+				t.Fatalf("Expected QueryError, got %v", err)
+			}
+			if !errors.Is(err, tc.expect) {
+				t.Errorf("Expected %v, got %v", tc.expect, err)
+			}
+		})
+
+		t.Run(tc.name+" Direct", func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			mock.ExpectQuery("SELECT a FROM b").
+				WillDelayFor(time.Second).
+				WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+
+			w, err := New(db, testPlaceholder{})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			_, err = w.QueryRaw(ctx, "SELECT a FROM b")
+			queryErr := &QueryError{}
+			if !errors.As(err, &queryErr) {
+				t.Fatalf("Expected QueryError, got %v", err)
+			}
+			if !errors.Is(err, tc.expect) {
+				t.Errorf("Expected %v, got %v", tc.expect, err)
+			}
+		})
+	}
+}
+
+func TestQueryErrorOp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		op  string
+		run func() error
+	}{{
+		op: OpExec,
+		run: func() error {
+			_, err := w.Exec(ctx, testSqlizer{str: "UPDATE b"})
+			return err
+		},
+	}, {
+		op: OpQuery,
+		run: func() error {
+			_, err := w.Query(ctx, testSqlizer{str: "SELECT a FROM b"})
+			return err
+		},
+	}, {
+		op: OpSelect,
+		run: func() error {
+			_, err := w.Select(ctx, testSqlizer{str: "SELECT a FROM b"})
+			return err
+		},
+	}} {
+		if tc.op == OpExec {
+			mock.ExpectExec("UPDATE b").WillReturnError(testError("failed"))
+		} else {
+			mock.ExpectQuery("SELECT a FROM b").WillReturnError(testError("failed"))
+		}
+
+		err := tc.run()
+		queryErr := &QueryError{}
+		if !errors.As(err, &queryErr) {
+			t.Fatalf("%s: expected QueryError, got %v", tc.op, err)
+		}
+		if queryErr.Op != tc.op {
+			t.Errorf("Expected op %s, got %q", tc.op, queryErr.Op)
+		}
+		if queryErr.Error() != "failed `"+queryErr.Statement+"` " {
+			t.Errorf("Error format changed: %s", queryErr.Error())
+		}
+	}
+}
+
+type wrappedConnection struct {
+	Connection
+}
+
+func TestWrapperStats(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, ok := NewPostgres(db).Stats(); !ok {
+		t.Errorf("Expected stats from *sql.DB")
+	}
+
+	if _, ok := NewPostgres(wrappedConnection{db}).Stats(); ok {
+		t.Errorf("Expected no stats from a non *sql.DB connection")
+	}
+}
+
+func TestExecReturning(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta("WITH x AS (SELECT 1) UPDATE b SET c = ! RETURNING id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+	rows, err := tx.ExecReturning(ctx, testSqlizer{
+		str:  "WITH x AS (SELECT 1) UPDATE b SET c = ? RETURNING id",
+		args: []interface{}{"c"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+	if count != 2 {
+		t.Errorf("Expected 2 rows, got %d", count)
+	}
+
+	if _, err := tx.ExecReturning(ctx, testSqlizer{
+		str: "UPDATE b SET c = 1",
+	}); err == nil {
+		t.Errorf("Expected error for statement without RETURNING")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+type requestIDKey struct{}
+
+func TestLoggerWithFields(t *testing.T) {
+	lines := []string{}
+	logger := LoggerWithFields(func(ctx context.Context, line string) {
+		lines = append(lines, line)
+	}, requestIDKey{}, "missing")
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	logger.LogQuery(ctx, "SELECT a FROM b WHERE c = $1", "c")
+	logger.LogQuery(context.Background(), "SELECT a FROM b")
+
+	want := []string{
+		"[req-1] QUERY SELECT a FROM b WHERE c = $1",
+		`[req-1]   $0 "c"`,
+		"QUERY SELECT a FROM b",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %v", len(want), lines)
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("Line %d: expected %q, got %q", i, line, lines[i])
+		}
+	}
+}
+
+func TestTxInvalidIsolation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	called := false
+	err = w.Transact(context.Background(), &TxOptions{
+		Isolation: sql.IsolationLevel(99),
+	}, func(ctx context.Context, tx Transaction) error {
+		called = true
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Expected error for invalid isolation level")
+	}
+	txErr := &TransactError{}
+	if !errors.As(err, &txErr) || txErr.Phase != PhaseBegin {
+		t.Errorf("Expected a begin TransactError, got %v", err)
+	}
+	if called {
+		t.Error("Callback should not be called")
+	}
+
+	// No Begin expected
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTransactOpts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.ShouldRetryTransaction = func(error) bool { return true }
+
+	ctx := context.Background()
+
+	callbackErr := testError("callback")
+	calls := 0
+	err = w.TransactOpts(ctx, func(ctx context.Context, tx Transaction) error {
+		calls++
+		return callbackErr
+	}, WithReadOnly(), WithRetryable(), WithRetryCount(2))
+	if !errors.Is(err, callbackErr) {
+		t.Errorf("Expected callback error, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected callback to run twice, ran %d times", calls)
+	}
+
+	if w.DefaultTxOptions.ReadOnly || w.DefaultTxOptions.RetryCount != 0 {
+		t.Errorf("DefaultTxOptions should not be modified")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTransactDefaultOptionsUnchanged(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	mock.MatchExpectationsInOrder(false)
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+	}
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defaults := *w.DefaultTxOptions
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	if !reflect.DeepEqual(*w.DefaultTxOptions, defaults) {
+		t.Errorf("DefaultTxOptions changed from %+v to %+v", defaults, *w.DefaultTxOptions)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestStrictReads(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Direct", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT a FROM b").
+			WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+		mock.ExpectRollback()
+
+		w := NewPostgres(db)
+		w.StrictReads = true
+
+		var a string
+		if err := w.SelectRow(ctx, testSqlizer{str: "SELECT a FROM b"}).Scan(&a); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sqrlx_strict_read").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET LOCAL transaction_read_only = on").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("UPDATE b SET a = 1 RETURNING a").
+			WillReturnError(testError("cannot execute UPDATE in a read-only transaction"))
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT sqrlx_strict_read").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT sqrlx_strict_read").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE b SET a = 2").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		w := NewPostgres(db)
+		w.StrictReads = true
+		w.SelectRetryCount = 1
+
+		err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+			if _, err := tx.Select(ctx, testSqlizer{str: "UPDATE b SET a = 1 RETURNING a"}); err == nil {
+				t.Errorf("Expected error from write in select")
+			}
+			// The transaction is still usable for writes
+			_, err := tx.Exec(ctx, testSqlizer{str: "UPDATE b SET a = 2"})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+}
+
+func TestTxRetryDeadlock(t *testing.T) {
+	ctx := context.Background()
+
+	deadlock := &pq.Error{Code: "40P01"}
+
+	lockRow := func(ctx context.Context, tx Transaction) error {
+		var id int64
+		return tx.QueryRow(ctx, testSqlizer{str: "SELECT id FROM b FOR UPDATE"}).Scan(&id)
+	}
+
+	t.Run("Retryable", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM b FOR UPDATE").WillReturnError(deadlock)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM b FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		w := NewPostgres(db)
+		if err := w.Transact(ctx, &TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			Retryable: true,
+		}, lockRow); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("Not Retryable, RetryDeadlocks", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM b FOR UPDATE").WillReturnError(deadlock)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM b FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		w := NewPostgres(db)
+		w.RetryDeadlocks = true
+		if err := w.Transact(ctx, &TxOptions{
+			Isolation: sql.LevelReadCommitted,
+		}, lockRow); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("Not Retryable", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM b FOR UPDATE").WillReturnError(deadlock)
+		mock.ExpectRollback()
+
+		w := NewPostgres(db)
+		err = w.Transact(ctx, &TxOptions{
+			Isolation: sql.LevelReadCommitted,
+		}, lockRow)
+		if !errors.Is(err, deadlock) {
+			t.Errorf("Expected deadlock error, got %v", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+}
+
+func TestTxNotRetryableRunsOnce(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cause := range []error{
+		&pq.Error{Code: "40001"},
+		&pq.Error{Code: "40P01"},
+	} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		w := NewPostgres(db)
+		calls := 0
+		err = w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+			calls++
+			return cause
+		})
+		if !errors.Is(err, cause) {
+			t.Errorf("Expected %v, got %v", cause, err)
+		}
+		if calls != 1 {
+			t.Errorf("%v: expected the callback to run once, ran %d times", cause, calls)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	}
+}
+
+func TestSetQueryLoggerConcurrent(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	mock.MatchExpectationsInOrder(false)
+
+	const transactions = 20
+	for i := 0; i < transactions; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE b SET c = 1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec("UPDATE b SET c = 2").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var logged int64
+	logger := CallbackLogger(func(ctx context.Context, statement string) {
+		atomic.AddInt64(&logged, 1)
+	})
+
+	done := make(chan struct{})
+	toggled := make(chan struct{})
+	go func() {
+		defer close(toggled)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				w.SetQueryLogger(logger)
+			} else {
+				w.SetQueryLogger(nil)
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < transactions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+				_, err := tx.Exec(ctx, testSqlizer{str: "UPDATE b SET c = 1"})
+				return err
+			}); err != nil {
+				t.Error(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-toggled
+
+	// The last logger set is used
+	w.SetQueryLogger(logger)
+	before := atomic.LoadInt64(&logged)
+	if _, err := w.ExecRaw(ctx, "UPDATE b SET c = 2"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if atomic.LoadInt64(&logged) != before+1 {
+		t.Errorf("Expected the statement to be logged")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+// driverLogger records the statements passed to LogQuery
+type driverLogger struct {
+	statements []string
+}
+
+func (dl *driverLogger) LogQuery(ctx context.Context, statement string, params ...interface{}) {
+	dl.statements = append(dl.statements, statement)
+}
+
+func TestQueryLoggerDriverStatement(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	selectSQL := "SELECT a FROM b WHERE c = $1 /* test */"
+	updateSQL := "UPDATE b SET a = $1 /* test */"
+
+	mock.ExpectQuery(selectSQL).WillReturnRows(sqlmock.NewRows([]string{"a"}))
+	mock.ExpectExec(updateSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectSQL).WillReturnRows(sqlmock.NewRows([]string{"a"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectSQL).WillReturnRows(sqlmock.NewRows([]string{"a"}))
+	mock.ExpectExec(updateSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := NewPostgres(db)
+	logger := &driverLogger{}
+	w.QueryLogger = logger
+	w.StatementRewriter = func(ctx context.Context, statement string) string {
+		return statement + " /* test */"
+	}
+
+	run := func(ctx context.Context, cmd Commander) error {
+		rows, err := cmd.Query(ctx, sqrl.Select("a").From("b").Where("c = ?", 1))
+		if err != nil {
+			return err
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		_, err = cmd.Exec(ctx, sqrl.Update("b").Set("a", 2))
+		return err
+	}
+
+	if err := run(ctx, w); err != nil {
+		t.Fatal(err.Error())
+	}
+	rows, err := w.QueryRaw(ctx, "SELECT a FROM b WHERE c = $1", 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+	if err := w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+		return run(ctx, tx)
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	want := []string{selectSQL, updateSQL, selectSQL, selectSQL, updateSQL}
+	if strings.Join(logger.statements, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the driver statements to be logged, got %q", logger.statements)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Only the read reaches the driver
+	mock.ExpectQuery("SELECT a FROM b").WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow(1))
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.DryRun = true
+
+	logged := []string{}
+	w.QueryLogger = CallbackLogger(func(ctx context.Context, statement string) {
+		logged = append(logged, statement)
+	})
+
+	res, err := w.Exec(ctx, testSqlizer{str: "UPDATE b SET a = ?", args: []interface{}{2}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count, err := res.RowsAffected(); err != nil || count != 0 {
+		t.Errorf("Expected 0 rows affected, got %d, %v", count, err)
+	}
+
+	var a int
+	if err := w.SelectRow(ctx, testSqlizer{str: "SELECT a FROM b"}).Scan(&a); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Writes which return rows can't be skipped, so fail before the driver
+	if _, err := w.ExecReturning(ctx, testSqlizer{str: "DELETE FROM b RETURNING a"}); !errors.Is(err, ErrDryRun) {
+		t.Errorf("ExecReturning: expected ErrDryRun, got %v", err)
+	}
+	if _, err := w.InsertReturningID(ctx, testSqlizer{str: "INSERT INTO b (a) VALUES (?)", args: []interface{}{1}}, "id"); !errors.Is(err, ErrDryRun) {
+		t.Errorf("InsertReturningID: expected ErrDryRun, got %v", err)
+	}
+	if _, err := w.UpsertReturningInserted(ctx, Upsert("b").Key("id", 1).Set("a", 2)); !errors.Is(err, ErrDryRun) {
+		t.Errorf("UpsertReturningInserted: expected ErrDryRun, got %v", err)
+	}
+
+	all := strings.Join(logged, "\n")
+	for _, want := range []string{"UPDATE b SET a = !", "SELECT a FROM b", "DELETE FROM b RETURNING a", "INSERT INTO b (a) VALUES (!) RETURNING id", "INSERT INTO b (id,a)"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected %q to be logged, got %q", want, logged)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestAutocommit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// No Begin or Commit
+	mock.ExpectExec("CREATE INDEX CONCURRENTLY idx_b ON b").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := NewPostgres(db)
+
+	ctx := context.Background()
+	err = w.Autocommit(ctx, func(ctx context.Context, cmd Commander) error {
+		_, err := cmd.ExecRaw(ctx, "CREATE INDEX CONCURRENTLY idx_b ON b (c)")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTxCommitErrors(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name            string
+		commitErr       error
+		retryAmbig      bool
+		expectRetry     bool
+		expectAmbiguous bool
+	}{{
+		name:        "Serialization failure",
+		commitErr:   &pq.Error{Code: "40001"},
+		expectRetry: true,
+	}, {
+		name:        "Constraint",
+		commitErr:   &pq.Error{Code: "23505"},
+		expectRetry: false,
+	}, {
+		name:            "Ambiguous",
+		commitErr:       driver.ErrBadConn,
+		expectRetry:     false,
+		expectAmbiguous: true,
+	}, {
+		name:            "Ambiguous Opted In",
+		commitErr:       &net.OpError{Op: "read", Err: testError("connection reset")},
+		retryAmbig:      true,
+		expectRetry:     true,
+		expectAmbiguous: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectCommit().WillReturnError(tc.commitErr)
+			if tc.expectRetry {
+				mock.ExpectBegin()
+				mock.ExpectCommit()
+			}
+
+			w := NewPostgres(db)
+			w.RetryAmbiguousCommits = tc.retryAmbig
+
+			calls := 0
+			err = w.Transact(ctx, &TxOptions{
+				Isolation: sql.LevelSerializable,
+				Retryable: true,
+			}, func(ctx context.Context, tx Transaction) error {
+				calls++
+				return nil
+			})
+
+			if tc.expectRetry {
+				if err != nil {
+					t.Fatalf("Expected retry to succeed, got %v", err)
+				}
+				if calls != 2 {
+					t.Errorf("Expected 2 calls, got %d", calls)
+				}
+			} else {
+				if !errors.Is(err, tc.commitErr) {
+					t.Errorf("Expected commit error, got %v", err)
+				}
+				ambiguous := &CommitAmbiguousError{}
+				if errors.As(err, &ambiguous) != tc.expectAmbiguous {
+					t.Errorf("Expected ambiguous %v, got %v", tc.expectAmbiguous, err)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err.Error())
+			}
+		})
+	}
+}
+
+func TestQueryLabel(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectQuery("SELECT a FROM b /* ListB */").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+	mock.ExpectExec("UPDATE b SET a = 1 /* bad* /label */").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE b SET a = 3 /* bad/ *nested* / */").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE b SET a = 4 /* / * / */").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE b SET a = 2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := NewPostgres(db)
+
+	ctx := context.Background()
+
+	var a string
+	if err := w.SelectRow(WithQueryLabel(ctx, "ListB"), testSqlizer{str: "SELECT a FROM b"}).Scan(&a); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The label can't end the comment early
+	if _, err := w.ExecRaw(WithQueryLabel(ctx, "bad*/label"), "UPDATE b SET a = 1"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Nor open a nested comment, which Postgres would need closed as well
+	if _, err := w.ExecRaw(WithQueryLabel(ctx, "bad/*nested*/"), "UPDATE b SET a = 3"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := w.ExecRaw(WithQueryLabel(ctx, "/*/"), "UPDATE b SET a = 4"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := w.ExecRaw(ctx, "UPDATE b SET a = 2"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+type typedTestLogger struct {
+	ops      []string
+	builders []string
+}
+
+func (tl *typedTestLogger) LogQuery(ctx context.Context, statement string, params ...interface{}) {
+	tl.ops = append(tl.ops, "untyped")
+}
+
+func (tl *typedTestLogger) LogTypedQuery(ctx context.Context, op string, statement string, params ...interface{}) {
+	tl.ops = append(tl.ops, op)
+	tl.builders = append(tl.builders, BuilderName(ctx))
+}
+
+func TestTypedQueryLogger(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT a FROM b").WillReturnRows(sqlmock.NewRows([]string{"a"}))
+	mock.ExpectQuery("SELECT a FROM b").WillReturnRows(sqlmock.NewRows([]string{"a"}))
+
+	w := NewPostgres(db)
+	logger := &typedTestLogger{}
+	w.QueryLogger = logger
+
+	ctx := context.Background()
+
+	if _, err := w.Exec(ctx, testSqlizer{str: "UPDATE b SET a = 1"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	rows, err := w.Query(ctx, testSqlizer{str: "SELECT a FROM b"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+	rows, err = w.Select(ctx, testSqlizer{str: "SELECT a FROM b"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+
+	want := []string{OpExec, OpQuery, OpSelect}
+	if strings.Join(logger.ops, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected ops %v, got %v", want, logger.ops)
+	}
+}
+
+func TestBuilderName(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectExec("INSERT INTO b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT a FROM b").WillReturnRows(sqlmock.NewRows([]string{"a"}))
+	mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, err := New(db, testPlaceholder{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	logger := &typedTestLogger{}
+	w.QueryLogger = logger
+
+	ctx := context.Background()
+
+	if _, err := w.Exec(ctx, Upsert("b").Key("id", 1).Set("a", 2)); err != nil {
+		t.Fatal(err.Error())
+	}
+	rows, err := w.Select(ctx, sqrl.Select("a").From("b"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows.Close()
+	if _, err := w.ExecRaw(ctx, "UPDATE b SET a = 1"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	want := []string{"sqrlx.UpsertBuilder", "sqrl.SelectBuilder", "raw"}
+	if strings.Join(logger.builders, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected builders %v, got %v", want, logger.builders)
+	}
+}
+
+func TestWrapperOptions(t *testing.T) {
+	w := NewPostgres(nil)
+	if w.DefaultTxOptions.Isolation != sql.LevelSerializable {
+		t.Errorf("Expected serializable by default, got %s", w.DefaultTxOptions.Isolation)
+	}
+
+	classifier := func(error) bool { return false }
+	w = NewPostgres(nil,
+		WithDefaultIsolation(sql.LevelReadCommitted),
+		WithTransactionRetries(2),
+		WithRetryClassifier(classifier),
+	)
+	if w.DefaultTxOptions.Isolation != sql.LevelReadCommitted {
+		t.Errorf("Expected read committed, got %s", w.DefaultTxOptions.Isolation)
+	}
+	if w.RetryCount != 2 {
+		t.Errorf("Expected RetryCount 2, got %d", w.RetryCount)
+	}
+	if w.ShouldRetryTransaction(testError("any")) {
+		t.Errorf("Expected custom classifier")
+	}
+
+	// Options don't modify defaults shared by other wrappers
+	if NewPostgres(nil).DefaultTxOptions.Isolation != sql.LevelSerializable {
+		t.Errorf("Default isolation should be unchanged")
+	}
+
+	w, err := New(nil, testPlaceholder{}, WithDefaultTxOptions(&TxOptions{ReadOnly: true}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !w.DefaultTxOptions.ReadOnly {
+		t.Errorf("Expected read only default options")
+	}
+}
+
+func TestRecordStatements(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT a FROM b").WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("A"))
+	mock.ExpectCommit()
+	mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := NewPostgres(db)
+	w.RecordStatements = true
+
+	ctx := context.Background()
+
+	if _, err := w.Exec(ctx, testSqlizer{str: "UPDATE b SET a = ?", args: []interface{}{1}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := w.Transact(ctx, nil, func(ctx context.Context, tx Transaction) error {
+		var a string
+		return tx.SelectRow(ctx, testSqlizer{str: "SELECT a FROM b"}).Scan(&a)
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recorded := w.DrainStatements()
+	if len(recorded) != 2 {
+		t.Fatalf("Expected 2 statements, got %v", recorded)
+	}
+	if recorded[0].Statement != "UPDATE b SET a = $1" || len(recorded[0].Args) != 1 {
+		t.Errorf("Unexpected first statement %v", recorded[0])
+	}
+	if recorded[1].Statement != "SELECT a FROM b" {
+		t.Errorf("Unexpected second statement %v", recorded[1])
+	}
+
+	if len(w.DrainStatements()) != 0 {
+		t.Errorf("Expected statements to be cleared")
+	}
+
+	w.RecordStatements = false
+	if _, err := w.ExecRaw(ctx, "UPDATE b SET a = 2"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(w.DrainStatements()) != 0 {
+		t.Errorf("Expected nothing recorded when disabled")
+	}
+}
+
+func TestTxContextCancelledStopsRetries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	w := NewPostgres(db)
+	w.ShouldRetryTransaction = func(error) bool { return true }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	err = w.Transact(ctx, &TxOptions{
+		Isolation: sql.LevelSerializable,
+		Retryable: true,
+	}, func(ctx context.Context, tx Transaction) error {
+		calls++
+		cancel()
+		return testError("retry")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context error, got %v", err)
+	}
+	if !errors.Is(err, testError("retry")) {
+		t.Errorf("Expected the error of the attempt, got %v", err)
+	}
+	txErr := &TransactError{}
+	if !errors.As(err, &txErr) || txErr.Phase != PhaseCallback {
+		t.Errorf("Expected a callback TransactError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestEmptyStatement(t *testing.T) {
+	ctx := context.Background()
+	tx, mock := testTransaction(t, 1)
+
+	for _, bb := range []Sqlizer{
+		testSqlizer{str: ""},
+		testSqlizer{str: " \n\t"},
+	} {
+		_, err := tx.Exec(ctx, bb)
+		if !errors.Is(err, ErrEmptyStatement) {
+			t.Errorf("Exec: expected ErrEmptyStatement, got %v", err)
+		}
+		queryErr := &QueryError{}
+		if !errors.As(err, &queryErr) {
+			t.Errorf("Exec: expected QueryError, got %T", err)
+		}
+
+		if _, err := tx.Query(ctx, bb); !errors.Is(err, ErrEmptyStatement) {
+			t.Errorf("Query: expected ErrEmptyStatement, got %v", err)
+		}
+		if _, err := tx.Select(ctx, bb); !errors.Is(err, ErrEmptyStatement) {
+			t.Errorf("Select: expected ErrEmptyStatement, got %v", err)
+		}
+	}
+
+	// Nothing reaches the driver
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
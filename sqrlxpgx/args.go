@@ -0,0 +1,21 @@
+package sqrlxpgx
+
+import (
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+// Args converts params built for sqrlx to pgx query args, e.g. for the
+// statement and params from RenderSQL. sqrlx.Binary params become []byte,
+// which pgx encodes in the binary format for bytea parameters, in place of
+// the text format used by lib/pq. Other params are passed through unchanged.
+func Args(params []interface{}) []interface{} {
+	args := make([]interface{}, len(params))
+	for idx, param := range params {
+		if binary, ok := param.(sqrlx.Binary); ok {
+			args[idx] = []byte(binary)
+			continue
+		}
+		args[idx] = param
+	}
+	return args
+}
@@ -0,0 +1,39 @@
+package sqrlxpgx
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+func TestArgsBinary(t *testing.T) {
+	data := []byte{0, 1, 2, 255}
+	args := Args([]interface{}{"id", sqrlx.Binary(data)})
+
+	if args[0] != "id" {
+		t.Errorf("Expected other args unchanged, got %v", args[0])
+	}
+
+	m := pgtype.NewMap()
+	if format := m.FormatCodeForOID(pgtype.ByteaOID); format != pgtype.BinaryFormatCode {
+		t.Fatalf("Expected pgx to prefer binary for bytea, got %d", format)
+	}
+
+	encoded, err := m.Encode(pgtype.ByteaOID, pgtype.BinaryFormatCode, args[1], nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("Expected the raw bytes on the wire, got %x", encoded)
+	}
+
+	var decoded []byte
+	if err := m.Scan(pgtype.ByteaOID, pgtype.BinaryFormatCode, encoded, &decoded); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("Round trip changed the data: %x", decoded)
+	}
+}
@@ -0,0 +1,58 @@
+// Package sqrlxpgx adapts pgx results to sqrlx, so the struct scanning helpers
+// can be used without going through database/sql. It is a separate package so
+// that only users of pgx depend on it.
+package sqrlxpgx
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+// FromPgxRows wraps pgx rows as sqrlx Rows, e.g. to use with ScanStruct,
+// ScanAll or Rows.Each.
+//
+// pgx reports errors differently to database/sql: Close does not return an
+// error, and an error while reading rows is only available from Err after
+// Close, or after Next returns false. The adapter's Close closes the pgx rows
+// then returns Err, so errors are not lost by callers which only check Close,
+// and Err is passed through unchanged.
+func FromPgxRows(rows pgx.Rows) *sqrlx.Rows {
+	return &sqrlx.Rows{
+		IRows: pgxRows{rows: rows},
+	}
+}
+
+// pgxRows implements sqrlx.IRows over pgx.Rows
+type pgxRows struct {
+	rows pgx.Rows
+}
+
+var _ sqrlx.IRows = pgxRows{}
+
+func (pr pgxRows) Scan(dest ...interface{}) error {
+	return pr.rows.Scan(dest...)
+}
+
+// Columns returns the names of the result fields, which pgx always has
+// available, so never errors.
+func (pr pgxRows) Columns() ([]string, error) {
+	fields := pr.rows.FieldDescriptions()
+	names := make([]string, len(fields))
+	for idx, field := range fields {
+		names[idx] = field.Name
+	}
+	return names, nil
+}
+
+func (pr pgxRows) Next() bool {
+	return pr.rows.Next()
+}
+
+func (pr pgxRows) Close() error {
+	pr.rows.Close()
+	return pr.rows.Err()
+}
+
+func (pr pgxRows) Err() error {
+	return pr.rows.Err()
+}
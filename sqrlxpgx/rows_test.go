@@ -0,0 +1,87 @@
+package sqrlxpgx
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+// fakePgxRows implements the parts of pgx.Rows used by the adapter
+type fakePgxRows struct {
+	pgx.Rows
+
+	fields []string
+	values [][]string
+	idx    int
+	err    error
+	closed bool
+}
+
+func (fr *fakePgxRows) FieldDescriptions() []pgconn.FieldDescription {
+	fields := make([]pgconn.FieldDescription, len(fr.fields))
+	for idx, name := range fr.fields {
+		fields[idx] = pgconn.FieldDescription{Name: name}
+	}
+	return fields
+}
+
+func (fr *fakePgxRows) Next() bool {
+	if fr.idx >= len(fr.values) {
+		return false
+	}
+	fr.idx++
+	return true
+}
+
+func (fr *fakePgxRows) Scan(dest ...interface{}) error {
+	for idx, val := range fr.values[fr.idx-1] {
+		*(dest[idx].(*string)) = val
+	}
+	return nil
+}
+
+func (fr *fakePgxRows) Close() {
+	fr.closed = true
+}
+
+func (fr *fakePgxRows) Err() error {
+	return fr.err
+}
+
+type testRow struct {
+	ID   string `sql:"id"`
+	Name string `sql:"name"`
+}
+
+func TestFromPgxRows(t *testing.T) {
+	pgxRows := &fakePgxRows{
+		fields: []string{"id", "name"},
+		values: [][]string{{"1", "a"}, {"2", "b"}},
+	}
+
+	values, err := scanTestRows(pgxRows)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(values) != 2 || values[0].Name != "a" || values[1].ID != "2" {
+		t.Errorf("Unexpected rows %v", values)
+	}
+	if !pgxRows.closed {
+		t.Errorf("Expected pgx rows to be closed")
+	}
+
+	readErr := errors.New("read error")
+	if _, err := scanTestRows(&fakePgxRows{
+		fields: []string{"id", "name"},
+		err:    readErr,
+	}); !errors.Is(err, readErr) {
+		t.Errorf("Expected read error, got %v", err)
+	}
+}
+
+func scanTestRows(rows pgx.Rows) ([]testRow, error) {
+	return sqrlx.ScanAll[testRow](FromPgxRows(rows))
+}
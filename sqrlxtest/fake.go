@@ -0,0 +1,363 @@
+// Package sqrlxtest provides fakes for testing code which uses sqrlx, without
+// a database or sqlmock, and RollbackTransactor for tests against a real
+// database.
+package sqrlxtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+// Call is a single statement run against a FakeTransactor
+type Call struct {
+	// Sqlizer is the builder passed to the command, nil for Raw commands
+	Sqlizer sqrlx.Sqlizer
+
+	// Statement and Args are the result of Sqlizer.ToSql(), before placeholder
+	// replacement
+	Statement string
+	Args      []interface{}
+}
+
+// FakeTransactor implements sqrlx.Transactor and sqrlx.Commander, recording
+// every statement it is asked to run and responding with results programmed
+// through On. Statements without a matching response return zero rows, and a
+// result with zero rows affected.
+type FakeTransactor struct {
+	lock      sync.Mutex
+	calls     []Call
+	responses []*Response
+}
+
+var _ sqrlx.Transactor = &FakeTransactor{}
+var _ sqrlx.Commander = &FakeTransactor{}
+
+// NewFakeTransactor returns an empty FakeTransactor
+func NewFakeTransactor() *FakeTransactor {
+	return &FakeTransactor{}
+}
+
+// Response is a canned result for statements containing a string
+type Response struct {
+	contains string
+	columns  []string
+	rows     [][]interface{}
+	result   sql.Result
+	err      error
+}
+
+// Rows sets the rows returned by queries matching the response
+func (r *Response) Rows(columns []string, rows ...[]interface{}) *Response {
+	r.columns = columns
+	r.rows = rows
+	return r
+}
+
+// Result sets the result returned by execs matching the response
+func (r *Response) Result(lastInsertID, rowsAffected int64) *Response {
+	r.result = fakeResult{
+		lastInsertID: lastInsertID,
+		rowsAffected: rowsAffected,
+	}
+	return r
+}
+
+// Error causes statements matching the response to fail with err
+func (r *Response) Error(err error) *Response {
+	r.err = err
+	return r
+}
+
+// On registers a response for any statement containing the given string.
+// Responses are matched in the order they were registered.
+func (ft *FakeTransactor) On(contains string) *Response {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	resp := &Response{
+		contains: contains,
+	}
+	ft.responses = append(ft.responses, resp)
+	return resp
+}
+
+// Calls returns all statements run so far, in order
+func (ft *FakeTransactor) Calls() []Call {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	calls := make([]Call, len(ft.calls))
+	copy(calls, ft.calls)
+	return calls
+}
+
+// Reset clears the recorded calls, keeping the programmed responses
+func (ft *FakeTransactor) Reset() {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	ft.calls = nil
+}
+
+func (ft *FakeTransactor) record(bb sqrlx.Sqlizer, statement string, args []interface{}) *Response {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	ft.calls = append(ft.calls, Call{
+		Sqlizer:   bb,
+		Statement: statement,
+		Args:      args,
+	})
+	for _, resp := range ft.responses {
+		if strings.Contains(statement, resp.contains) {
+			return resp
+		}
+	}
+	return &Response{}
+}
+
+func (ft *FakeTransactor) exec(bb sqrlx.Sqlizer, statement string, args []interface{}) (sql.Result, error) {
+	resp := ft.record(bb, statement, args)
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	if resp.result == nil {
+		return fakeResult{}, nil
+	}
+	return resp.result, nil
+}
+
+func (ft *FakeTransactor) query(bb sqrlx.Sqlizer, statement string, args []interface{}) (*sqrlx.Rows, error) {
+	resp := ft.record(bb, statement, args)
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	return &sqrlx.Rows{
+		IRows: &fakeRows{
+			columns: resp.columns,
+			rows:    resp.rows,
+			idx:     -1,
+		},
+	}, nil
+}
+
+func rowFromRes(rows *sqrlx.Rows, err error) *sqrlx.Row {
+	if err != nil {
+		return &sqrlx.Row{
+			Rows: &fakeRows{err: err},
+		}
+	}
+	return &sqrlx.Row{
+		Rows: rows,
+	}
+}
+
+// Transact runs cb once with a transaction backed by the FakeTransactor. The
+// options are ignored. OnCommit hooks run when cb returns nil, OnRollback hooks
+// when it returns an error or calls MarkRollback.
+func (ft *FakeTransactor) Transact(ctx context.Context, opts *sqrlx.TxOptions, cb sqrlx.Callback) error {
+	extras := &fakeTxExtras{}
+	err := cb(ctx, sqrlx.Tx{
+		Commander: ft,
+		TxExtras:  extras,
+	})
+	extras.end(err)
+	return err
+}
+
+func (ft *FakeTransactor) Autocommit(ctx context.Context, cb sqrlx.AutocommitCallback) error {
+	return cb(ctx, ft)
+}
+
+func (ft *FakeTransactor) ExecRaw(ctx context.Context, statement string, params ...interface{}) (sql.Result, error) {
+	return ft.exec(nil, statement, params)
+}
+
+func (ft *FakeTransactor) Exec(ctx context.Context, bb sqrlx.Sqlizer) (sql.Result, error) {
+	statement, args, err := bb.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return ft.exec(bb, statement, args)
+}
+
+func (ft *FakeTransactor) ExecReturning(ctx context.Context, bb sqrlx.Sqlizer) (*sqrlx.Rows, error) {
+	statement, args, err := bb.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if !returningClause.MatchString(statement) {
+		return nil, fmt.Errorf("ExecReturning requires a RETURNING clause")
+	}
+	return ft.query(bb, statement, args)
+}
+
+func (ft *FakeTransactor) QueryRaw(ctx context.Context, statement string, params ...interface{}) (*sqrlx.Rows, error) {
+	return ft.query(nil, statement, params)
+}
+
+func (ft *FakeTransactor) Query(ctx context.Context, bb sqrlx.Sqlizer) (*sqrlx.Rows, error) {
+	statement, args, err := bb.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return ft.query(bb, statement, args)
+}
+
+func (ft *FakeTransactor) QueryRowRaw(ctx context.Context, statement string, params ...interface{}) *sqrlx.Row {
+	return rowFromRes(ft.QueryRaw(ctx, statement, params...))
+}
+
+func (ft *FakeTransactor) QueryRow(ctx context.Context, bb sqrlx.Sqlizer) *sqrlx.Row {
+	return rowFromRes(ft.Query(ctx, bb))
+}
+
+func (ft *FakeTransactor) SelectRow(ctx context.Context, bb sqrlx.Sqlizer) *sqrlx.Row {
+	return rowFromRes(ft.Query(ctx, bb))
+}
+
+func (ft *FakeTransactor) Select(ctx context.Context, bb sqrlx.Sqlizer) (*sqrlx.Rows, error) {
+	return ft.Query(ctx, bb)
+}
+
+func (ft *FakeTransactor) SelectWithRetries(ctx context.Context, retryCount int, bb sqrlx.Sqlizer) (*sqrlx.Rows, error) {
+	return ft.Query(ctx, bb)
+}
+
+func (ft *FakeTransactor) SelectRawWithRetries(ctx context.Context, retryCount int, statement string, params ...interface{}) (*sqrlx.Rows, error) {
+	return ft.QueryRaw(ctx, statement, params...)
+}
+
+func (ft *FakeTransactor) Insert(ctx context.Context, bb sqrlx.Sqlizer) (sql.Result, error) {
+	return ft.Exec(ctx, bb)
+}
+
+func (ft *FakeTransactor) InsertRow(ctx context.Context, bb sqrlx.Sqlizer) (bool, error) {
+	res, err := ft.Exec(ctx, bb)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		return false, nil
+	}
+	if count == 1 {
+		return true, nil
+	}
+	return false, fmt.Errorf("%d rows effected by InsertRow", count)
+}
+
+var returningClause = regexp.MustCompile(`(?i)\bRETURNING\b`)
+
+func (ft *FakeTransactor) InsertReturningID(ctx context.Context, bb sqrlx.Sqlizer, idColumn string) (int64, error) {
+	statement, args, err := bb.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	if !returningClause.MatchString(statement) {
+		statement = statement + " RETURNING " + idColumn
+	}
+
+	var id int64
+	if err := rowFromRes(ft.query(bb, statement, args)).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("InsertReturningID returned no rows: %w", err)
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+func (ft *FakeTransactor) UpsertReturningInserted(ctx context.Context, bb *sqrlx.UpsertBuilder) (bool, error) {
+	upsert := *bb
+	upsert.ReturningInserted()
+
+	var inserted bool
+	if err := ft.QueryRow(ctx, upsert).Scan(&inserted); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("UpsertReturningInserted returned no rows: %w", err)
+		}
+		return false, err
+	}
+	return inserted, nil
+}
+
+func (ft *FakeTransactor) InsertStruct(ctx context.Context, tableName string, vals ...interface{}) (sql.Result, error) {
+	bb, err := sqrlx.InsertStruct(tableName, vals...)
+	if err != nil {
+		return nil, err
+	}
+	return ft.Exec(ctx, bb)
+}
+
+func (ft *FakeTransactor) Update(ctx context.Context, bb sqrlx.Sqlizer) (sql.Result, error) {
+	return ft.Exec(ctx, bb)
+}
+
+func (ft *FakeTransactor) Delete(ctx context.Context, bb sqrlx.Sqlizer) (sql.Result, error) {
+	return ft.Exec(ctx, bb)
+}
+
+// fakeTxExtras records the hooks of a fake transaction, which commits when
+// the callback returns nil unless marked for rollback
+type fakeTxExtras struct {
+	rollbackOnly bool
+	onCommit     []func()
+	onRollback   []func()
+}
+
+func (fe *fakeTxExtras) Reset(context.Context) error {
+	fe.onCommit = nil
+	fe.onRollback = nil
+	return nil
+}
+
+func (fe *fakeTxExtras) PrepareRaw(context.Context, string) (*sql.Stmt, error) {
+	return nil, fmt.Errorf("PrepareRaw is not supported by FakeTransactor")
+}
+
+// MarkRollback runs the OnRollback hooks in place of OnCommit, statements are
+// still recorded as they run
+func (fe *fakeTxExtras) MarkRollback() {
+	fe.rollbackOnly = true
+}
+
+func (fe *fakeTxExtras) OnCommit(fn func()) {
+	fe.onCommit = append(fe.onCommit, fn)
+}
+
+func (fe *fakeTxExtras) OnRollback(fn func()) {
+	fe.onRollback = append(fe.onRollback, fn)
+}
+
+// end runs the hooks for the outcome of the callback
+func (fe *fakeTxExtras) end(err error) {
+	hooks := fe.onCommit
+	if err != nil || fe.rollbackOnly {
+		hooks = fe.onRollback
+	}
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+type fakeResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
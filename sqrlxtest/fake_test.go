@@ -0,0 +1,81 @@
+package sqrlxtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	sq "github.com/elgris/sqrl"
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+func TestFakeTransactor(t *testing.T) {
+	ctx := context.Background()
+	ft := NewFakeTransactor()
+
+	ft.On("SELECT name FROM users").Rows([]string{"name"}, []interface{}{"alice"}, []interface{}{"bob"})
+	ft.On("DELETE FROM users").Result(0, 2)
+	failure := errors.New("failure")
+	ft.On("UPDATE users").Error(failure)
+
+	var names []string
+	var deleted int64
+	err := ft.Transact(ctx, nil, func(ctx context.Context, tx sqrlx.Transaction) error {
+		rows, err := tx.Select(ctx, sq.Select("name").From("users").Where("active = ?", true))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+
+		res, err := tx.Delete(ctx, sq.Delete("users").Where("id = ?", 5))
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(names) != 2 || names[0] != "alice" || names[1] != "bob" {
+		t.Errorf("Unexpected names %v", names)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 rows deleted, got %d", deleted)
+	}
+
+	if _, err := ft.Exec(ctx, sq.Update("users").Set("name", "x")); !errors.Is(err, failure) {
+		t.Errorf("Expected programmed error, got %v", err)
+	}
+
+	calls := ft.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("Expected 3 calls, got %d", len(calls))
+	}
+	if calls[0].Statement != "SELECT name FROM users WHERE active = ?" {
+		t.Errorf("Unexpected statement %s", calls[0].Statement)
+	}
+	if len(calls[1].Args) != 1 || calls[1].Args[0] != 5 {
+		t.Errorf("Unexpected args %v", calls[1].Args)
+	}
+}
+
+func TestFakeTransactorRowError(t *testing.T) {
+	ctx := context.Background()
+	ft := NewFakeTransactor()
+	failure := errors.New("failure")
+	ft.On("SELECT").Error(failure)
+
+	var name string
+	if err := ft.SelectRow(ctx, sq.Select("name").From("users")).Scan(&name); !errors.Is(err, failure) {
+		t.Errorf("Expected programmed error, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package sqrlxtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+// RollbackTransactor runs every transaction of a test against a real
+// database, inside one outer transaction which is rolled back when the test
+// ends, so tests don't see each other's writes. Each Transact runs in a
+// SAVEPOINT, released when the callback returns nil and rolled back to when it
+// returns an error, so a later Transact of the same test sees the writes of
+// earlier ones, as it would with commits.
+//
+// Transactions run one at a time, and must not be nested. TxOptions are
+// ignored, the isolation level is the one of the outer transaction.
+type RollbackTransactor struct {
+	tx sqrlx.Transaction
+
+	lock      sync.Mutex
+	savepoint int
+
+	closeOnce sync.Once
+	release   chan struct{}
+	done      chan error
+}
+
+var _ sqrlx.Transactor = &RollbackTransactor{}
+
+// NewRollbackTransactor begins the outer transaction on db, usually a
+// *sqrlx.Wrapper connected to a test database, and rolls it back in
+// t.Cleanup.
+func NewRollbackTransactor(t testing.TB, db sqrlx.Transactor) *RollbackTransactor {
+	t.Helper()
+
+	rt := &RollbackTransactor{
+		release: make(chan struct{}),
+		done:    make(chan error, 1),
+	}
+
+	ready := make(chan sqrlx.Transaction)
+	go func() {
+		rt.done <- db.Transact(context.Background(), nil, func(ctx context.Context, tx sqrlx.Transaction) error {
+			tx.MarkRollback()
+			ready <- tx
+			<-rt.release
+			return nil
+		})
+	}()
+
+	select {
+	case rt.tx = <-ready:
+	case err := <-rt.done:
+		t.Fatalf("begin rollback transaction: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if err := rt.Close(); err != nil {
+			t.Errorf("rollback transaction: %s", err)
+		}
+	})
+
+	return rt
+}
+
+// Close rolls back the outer transaction, discarding everything written by
+// the test. It is called by t.Cleanup, and only needs to be called to roll
+// back earlier.
+func (rt *RollbackTransactor) Close() error {
+	var err error
+	rt.closeOnce.Do(func() {
+		close(rt.release)
+		err = <-rt.done
+	})
+	return err
+}
+
+// Transact runs cb in a SAVEPOINT of the outer transaction, returning the
+// error from cb.
+func (rt *RollbackTransactor) Transact(ctx context.Context, opts *sqrlx.TxOptions, cb sqrlx.Callback) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	rt.savepoint++
+	sp := &savepointTx{
+		Transaction: rt.tx,
+		name:        fmt.Sprintf("sqrlxtest_%d", rt.savepoint),
+	}
+
+	if _, err := rt.tx.ExecRaw(ctx, "SAVEPOINT "+sp.name); err != nil {
+		return err
+	}
+
+	if err := cb(ctx, sp); err != nil {
+		if _, rbErr := rt.tx.ExecRaw(ctx, "ROLLBACK TO SAVEPOINT "+sp.name); rbErr != nil {
+			return fmt.Errorf("%w, rollback to savepoint: %s", err, rbErr)
+		}
+		runHooks(sp.onRollback)
+		return err
+	}
+
+	if sp.rollbackOnly {
+		if _, err := rt.tx.ExecRaw(ctx, "ROLLBACK TO SAVEPOINT "+sp.name); err != nil {
+			return err
+		}
+		runHooks(sp.onRollback)
+		return nil
+	}
+
+	if _, err := rt.tx.ExecRaw(ctx, "RELEASE SAVEPOINT "+sp.name); err != nil {
+		return err
+	}
+	runHooks(sp.onCommit)
+	return nil
+}
+
+// Autocommit runs cb as Transact, so statements which can't run in a
+// transaction, e.g. CREATE INDEX CONCURRENTLY, fail.
+func (rt *RollbackTransactor) Autocommit(ctx context.Context, cb sqrlx.AutocommitCallback) error {
+	return rt.Transact(ctx, nil, func(ctx context.Context, tx sqrlx.Transaction) error {
+		return cb(ctx, tx)
+	})
+}
+
+// savepointTx scopes the TxExtras of the outer transaction to one savepoint,
+// so MarkRollback, Reset and the hooks act as they would on a transaction of
+// its own.
+type savepointTx struct {
+	sqrlx.Transaction
+	name string
+
+	rollbackOnly bool
+	onCommit     []func()
+	onRollback   []func()
+}
+
+func (sp *savepointTx) MarkRollback() {
+	sp.rollbackOnly = true
+}
+
+func (sp *savepointTx) OnCommit(fn func()) {
+	sp.onCommit = append(sp.onCommit, fn)
+}
+
+func (sp *savepointTx) OnRollback(fn func()) {
+	sp.onRollback = append(sp.onRollback, fn)
+}
+
+// Reset rolls back to the start of the savepoint, which is kept
+func (sp *savepointTx) Reset(ctx context.Context) error {
+	if _, err := sp.Transaction.ExecRaw(ctx, "ROLLBACK TO SAVEPOINT "+sp.name); err != nil {
+		return err
+	}
+	sp.rollbackOnly = false
+	sp.onCommit = nil
+	sp.onRollback = nil
+	return nil
+}
+
+func runHooks(hooks []func()) {
+	for _, fn := range hooks {
+		fn()
+	}
+}
@@ -0,0 +1,79 @@
+package sqrlxtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pentops/sqrlx.go/sqrlx"
+)
+
+func TestRollbackTransactor(t *testing.T) {
+	ctx := context.Background()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec("SAVEPOINT sqrlxtest_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT sqrlxtest_1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// The second transaction sees the row written by the first
+	mock.ExpectExec("SAVEPOINT sqrlxtest_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	mock.ExpectExec("RELEASE SAVEPOINT sqrlxtest_2").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec("SAVEPOINT sqrlxtest_3").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT sqrlxtest_3").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectRollback()
+
+	rt := NewRollbackTransactor(t, sqrlx.NewPostgres(db))
+
+	committed := false
+	if err := rt.Transact(ctx, nil, func(ctx context.Context, tx sqrlx.Transaction) error {
+		tx.OnCommit(func() { committed = true })
+		_, err := tx.ExecRaw(ctx, "INSERT INTO users (name) VALUES ('alice')")
+		return err
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !committed {
+		t.Error("Expected OnCommit to run when the savepoint is released")
+	}
+
+	var name string
+	if err := rt.Transact(ctx, nil, func(ctx context.Context, tx sqrlx.Transaction) error {
+		return tx.QueryRowRaw(ctx, "SELECT name FROM users").Scan(&name)
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if name != "alice" {
+		t.Errorf("Expected alice, got %q", name)
+	}
+
+	failure := errors.New("failure")
+	rolledBack := false
+	if err := rt.Transact(ctx, nil, func(ctx context.Context, tx sqrlx.Transaction) error {
+		tx.OnRollback(func() { rolledBack = true })
+		return failure
+	}); !errors.Is(err, failure) {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+	if !rolledBack {
+		t.Error("Expected OnRollback to run when the savepoint is rolled back")
+	}
+
+	if err := rt.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+package sqrlxtest
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// fakeRows implements sqrlx.IRows over canned values
+type fakeRows struct {
+	columns []string
+	rows    [][]interface{}
+	idx     int
+	err     error
+}
+
+func (fr *fakeRows) Columns() ([]string, error) {
+	if fr.err != nil {
+		return nil, fr.err
+	}
+	return fr.columns, nil
+}
+
+func (fr *fakeRows) Next() bool {
+	if fr.err != nil {
+		return false
+	}
+	if fr.idx+1 >= len(fr.rows) {
+		return false
+	}
+	fr.idx++
+	return true
+}
+
+func (fr *fakeRows) Close() error {
+	return nil
+}
+
+func (fr *fakeRows) Err() error {
+	return fr.err
+}
+
+func (fr *fakeRows) Scan(dest ...interface{}) error {
+	if fr.idx < 0 || fr.idx >= len(fr.rows) {
+		return fmt.Errorf("Scan called without calling Next")
+	}
+	row := fr.rows[fr.idx]
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(row), len(dest))
+	}
+	for idx, val := range row {
+		if err := assign(dest[idx], val); err != nil {
+			return fmt.Errorf("scanning column %d: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+func assign(dest interface{}, val interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(val)
+	}
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("destination must be a non-nil pointer, got %T", dest)
+	}
+	dv = dv.Elem()
+
+	if val == nil {
+		dv.Set(reflect.Zero(dv.Type()))
+		return nil
+	}
+
+	sv := reflect.ValueOf(val)
+	if dv.Kind() == reflect.Ptr && !sv.Type().AssignableTo(dv.Type()) {
+		ptr := reflect.New(dv.Type().Elem())
+		if err := assign(ptr.Interface(), val); err != nil {
+			return err
+		}
+		dv.Set(ptr)
+		return nil
+	}
+
+	if sv.Type().AssignableTo(dv.Type()) {
+		dv.Set(sv)
+		return nil
+	}
+	if sv.Type().ConvertibleTo(dv.Type()) && isString(sv.Kind()) == isString(dv.Kind()) {
+		dv.Set(sv.Convert(dv.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot assign %T to %s", val, dv.Type())
+}
+
+func isString(kind reflect.Kind) bool {
+	return kind == reflect.String
+}